@@ -0,0 +1,209 @@
+package webtail
+
+// This file holds rolling top-N counters of parsed line fields
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+const (
+	// counted minutes
+	aggregateBuckets = 15
+	// max counted values per field, the rest is counted as aggregateOther
+	maxAggregateValues = 10000
+	// value of fields which are not counted separately
+	aggregateOther = "<other>"
+	// tables push period
+	aggregateEvery = 5 * time.Second
+)
+
+// Aggregate query defaults and limits
+const (
+	aggregateWindow   = 5
+	aggregateLimit    = 10
+	maxAggregateLimit = 100
+)
+
+// aggregateWindows holds windows of table row in minutes
+var aggregateWindows = []int{1, 5, 15}
+
+// Aggregate errors
+var (
+	ErrAggregateDisabled = errors.New("aggregation is not enabled")
+	ErrAggregateField    = errors.New("field is not aggregated")
+	ErrAggregateWindow   = errors.New("window must be 1, 5 or 15")
+)
+
+// AggregateQuery holds aggregate subscription options
+type AggregateQuery struct {
+	Fields []string `json:"fields,omitempty"` // all aggregated fields of channel if empty
+	Window int      `json:"window,omitempty"` // rows order window in minutes
+	Limit  int      `json:"limit,omitempty"`  // rows per field
+}
+
+// AggregateRow holds counts of field value
+type AggregateRow struct {
+	Value string `json:"value"`
+	M1    uint64 `json:"1m"`
+	M5    uint64 `json:"5m"`
+	M15   uint64 `json:"15m"`
+}
+
+// AggregateMessage holds outgoing top-N tables
+type AggregateMessage struct {
+	Type    string                    `json:"type"`
+	Channel string                    `json:"channel"`
+	Window  int                       `json:"window"`
+	Data    map[string][]AggregateRow `json:"data"`
+}
+
+// fieldCounters holds counters of field values
+type fieldCounters map[string]map[string]*rollingCounts
+
+// newFieldCounters creates counters for fields
+func newFieldCounters(fields []string) fieldCounters {
+	rv := make(fieldCounters, len(fields))
+	for _, f := range fields {
+		rv[f] = make(map[string]*rollingCounts)
+	}
+	return rv
+}
+
+// add counts values of line fields
+func (fc fieldCounters) add(fields map[string]interface{}, now time.Time) {
+	for name, values := range fc {
+		v, ok := fields[name]
+		if !ok || v == nil {
+			continue
+		}
+		value := valueString(v)
+		c, ok := values[value]
+		if !ok {
+			if len(values) >= maxAggregateValues {
+				sweepCounts(values, now)
+			}
+			if len(values) >= maxAggregateValues {
+				value = aggregateOther
+				c = values[value]
+			}
+			if c == nil {
+				counts := newMinuteCounts(aggregateBuckets)
+				c = &counts
+				values[value] = c
+			}
+		}
+		c.add(now)
+	}
+}
+
+// sweepCounts drops values not seen within counted minutes
+func sweepCounts(values map[string]*rollingCounts, now time.Time) {
+	for k, c := range values {
+		if c.count(aggregateBuckets*time.Minute, now) == 0 {
+			delete(values, k)
+		}
+	}
+}
+
+// top returns field values ordered by count within window
+func (fc fieldCounters) top(field string, window, limit int, now time.Time) []AggregateRow {
+	rv := []AggregateRow{}
+	for value, c := range fc[field] {
+		row := AggregateRow{
+			Value: value,
+			M1:    c.count(time.Minute, now),
+			M5:    c.count(5*time.Minute, now),
+			M15:   c.count(15*time.Minute, now),
+		}
+		if row.M15 > 0 {
+			rv = append(rv, row)
+		}
+	}
+	key := func(row AggregateRow) uint64 {
+		switch window {
+		case 1:
+			return row.M1
+		case 15:
+			return row.M15
+		}
+		return row.M5
+	}
+	slices.SortFunc(rv, func(a, b AggregateRow) int {
+		return cmp.Or(cmp.Compare(key(b), key(a)), cmp.Compare(a.Value, b.Value))
+	})
+	if len(rv) > limit {
+		rv = rv[:limit]
+	}
+	return rv
+}
+
+// AggregateAdd counts fields of log line if channel has aggregated fields
+func (ts *TailService) AggregateAdd(msg *TailMessage) {
+	w, ok := ts.workers[msg.Channel]
+	if !ok || w.Counters == nil || msg.Type != "log" || msg.Fields == nil {
+		return
+	}
+	w.Counters.add(msg.Fields, time.Now())
+}
+
+// aggregateQuery checks query against channel settings and fills defaults
+func (ts *TailService) aggregateQuery(channel string, query *AggregateQuery) (*AggregateQuery, error) {
+	set := ts.TailSettings(channel)
+	if len(set.Aggregate) == 0 {
+		return nil, ErrAggregateDisabled
+	}
+	rv := &AggregateQuery{Fields: set.Aggregate, Window: aggregateWindow, Limit: aggregateLimit}
+	if query == nil {
+		return rv, nil
+	}
+	for _, f := range query.Fields {
+		if !slices.Contains(set.Aggregate, f) {
+			return nil, ErrAggregateField
+		}
+	}
+	if len(query.Fields) > 0 {
+		rv.Fields = query.Fields
+	}
+	if query.Window != 0 {
+		if !slices.Contains(aggregateWindows, query.Window) {
+			return nil, ErrAggregateWindow
+		}
+		rv.Window = query.Window
+	}
+	if query.Limit > 0 {
+		rv.Limit = min(query.Limit, maxAggregateLimit)
+	}
+	return rv, nil
+}
+
+// AggregateTable returns top-N tables message of channel
+func (ts *TailService) AggregateTable(channel string, query *AggregateQuery) []byte {
+	msg := AggregateMessage{Type: "aggregate", Channel: channel, Window: query.Window, Data: make(map[string][]AggregateRow)}
+	if w, ok := ts.workers[channel]; ok && w.Counters != nil {
+		now := time.Now()
+		for _, f := range query.Fields {
+			msg.Data[f] = w.Counters.top(f, query.Window, query.Limit, now)
+		}
+	}
+	data, _ := json.Marshal(msg)
+	return data
+}
+
+// aggregateTick pushes tables to aggregate subscribers
+func (h *Hub) aggregateTick(now time.Time) {
+	if now.Before(h.aggregateAt) {
+		return
+	}
+	h.aggregateAt = now.Add(aggregateEvery)
+	for channel, subs := range h.subscribers {
+		for client, sub := range subs {
+			if sub.aggregate != nil {
+				h.send(client, h.workers.AggregateTable(channel, sub.aggregate))
+			}
+		}
+	}
+}
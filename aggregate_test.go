@@ -0,0 +1,42 @@
+package webtail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCombined(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{
+		"remote_addr": "10.0.0.1",
+		"time":        "10/Oct/2024:13:55:36 +0000",
+		"method":      "GET",
+		"path":        "/api?q=1",
+		"protocol":    "HTTP/1.1",
+		"status":      "404",
+		"bytes":       "512",
+		"user_agent":  "curl/8.0",
+	}, parseCombined(`10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "GET /api?q=1 HTTP/1.1" 404 512 "-" "curl/8.0"`))
+	assert.Nil(t, parseCombined("level=info msg=x"))
+}
+
+func TestFieldCounters(t *testing.T) {
+	fc := newFieldCounters([]string{"remote_addr", "status"})
+	now := time.Unix(1700000000, 0)
+	for i, ip := range []string{"1.1.1.1", "2.2.2.2", "2.2.2.2", "3.3.3.3"} {
+		// 1.1.1.1 is seen 10 minutes ago
+		at := now
+		if i == 0 {
+			at = now.Add(-10 * time.Minute)
+		}
+		fc.add(map[string]interface{}{"remote_addr": ip, "status": float64(200)}, at)
+	}
+	fc.add(map[string]interface{}{"remote_addr": "1.1.1.1"}, now.Add(-10*time.Minute))
+	assert.Equal(t, []AggregateRow{
+		{Value: "2.2.2.2", M1: 2, M5: 2, M15: 2},
+		{Value: "3.3.3.3", M1: 1, M5: 1, M15: 1},
+	}, fc.top("remote_addr", 5, 2, now))
+	assert.Equal(t, "1.1.1.1", fc.top("remote_addr", 15, 1, now)[0].Value)
+	assert.Equal(t, []AggregateRow{{Value: "200", M1: 3, M5: 3, M15: 4}}, fc.top("status", 5, 10, now))
+}
@@ -0,0 +1,458 @@
+package webtail
+
+// This file holds alert rules evaluated by pinned tail workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert defaults and limits
+const (
+	alertWindow  = time.Minute
+	maxAlertHits = 10000
+	webhookQueue = 100
+	webhookWait  = 10 * time.Second
+)
+
+// Metric names
+const (
+	metricAlerts        = "webtail_alerts_total"
+	metricWebhookErrors = "webtail_alert_webhook_errors_total"
+)
+
+// AlertRule holds alert for lines of files matched by Glob
+type AlertRule struct {
+	Name      string        `yaml:"name"`
+	Root      string        `yaml:"root"`      // root name, rule is applied to all roots if empty
+	Glob      string        `yaml:"glob"`      // file path relative to root
+	Pattern   string        `yaml:"pattern"`   // line regexp
+	Expr      string        `yaml:"expr"`      // parsed fields condition, see expr.go
+	Threshold int           `yaml:"threshold"` // matches within window to fire, 1 if not set
+	Window    time.Duration `yaml:"window"`    // 1m if not set
+	Cooldown  time.Duration `yaml:"cooldown"`  // min time between firings
+	Webhooks  []string      `yaml:"webhooks"`  // Config.AlertWebhooks if empty
+}
+
+// AlertEvent holds alert state change
+type AlertEvent struct {
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	Count     int       `json:"count"` // matches within window
+	Threshold int       `json:"threshold"`
+	File      string    `json:"file,omitempty"` // file of the last matched line
+	Line      string    `json:"line,omitempty"` // last matched line
+	Time      time.Time `json:"time"`
+}
+
+// AlertMessage holds outgoing alert
+type AlertMessage struct {
+	Type string      `json:"type"`
+	Data *AlertEvent `json:"data"`
+}
+
+// alertState holds runtime state of alert rule
+type alertState struct {
+	rule   AlertRule
+	re     *regexp.Regexp
+	expr   exprNode
+	hits   []time.Time
+	firing bool
+	fired  time.Time
+	file   string
+	line   string
+}
+
+// pinnedChannel holds alert rules of channel which is tailed without subscribers
+type pinnedChannel struct {
+	rules []*alertState
+	// lines which ended before this offset existed on pin
+	from int64
+	// offset of the last line, used to detect truncation
+	last int64
+}
+
+// alerter holds alert rules of hub
+type alerter struct {
+	rules  []*alertState
+	pinned map[string]*pinnedChannel
+	// channels not pinned because of Config.AlertMaxPins, logged once
+	skipped map[string]bool
+	sender  *webhookSender
+}
+
+// webhookJob holds alert event delivery
+type webhookJob struct {
+	urls    []string
+	body    []byte
+	retries int
+}
+
+// webhookSender delivers alert events to webhooks
+// Every URL has its own queue, so dead webhook does not delay others
+type webhookSender struct {
+	log    logr.Logger
+	client *http.Client
+	queue  chan webhookJob
+	// per URL queues, used by run goroutine only
+	urls map[string]chan webhookJob
+	// delay before the first retry, doubled for next ones
+	delay   time.Duration
+	metrics *Metrics
+}
+
+// checkAlertRule checks alert rule values
+func checkAlertRule(rule AlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name required")
+	}
+	if rule.Glob == "" || !ValidGlob(rule.Glob) {
+		return fmt.Errorf("bad glob %q", rule.Glob)
+	}
+	if rule.Pattern == "" && rule.Expr == "" {
+		return fmt.Errorf("pattern or expr required")
+	}
+	if rule.Threshold < 0 || rule.Window < 0 || rule.Cooldown < 0 {
+		return fmt.Errorf("negative threshold, window or cooldown")
+	}
+	if _, err := newAlertState(rule); err != nil {
+		return err
+	}
+	return checkWebhooks(rule.Webhooks)
+}
+
+// checkWebhooks checks webhook URLs
+func checkWebhooks(urls []string) error {
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("bad webhook %q", s)
+		}
+	}
+	return nil
+}
+
+// newAlertState compiles alert rule
+func newAlertState(rule AlertRule) (*alertState, error) {
+	if rule.Threshold == 0 {
+		rule.Threshold = 1
+	}
+	if rule.Window == 0 {
+		rule.Window = alertWindow
+	}
+	rv := &alertState{rule: rule}
+	var err error
+	if rv.re, err = regexp.Compile(rule.Pattern); err != nil {
+		return nil, fmt.Errorf("pattern: %w", err)
+	}
+	if rule.Expr != "" {
+		if rv.expr, err = compileExpr(rule.Expr); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// newAlerter creates alert rules state, state of unchanged rules and pinned channels are kept from prev
+// Pinned channels have to be updated by alertSync
+func newAlerter(rules []AlertRule, prev *alerter) *alerter {
+	rv := &alerter{pinned: prev.pinned, skipped: make(map[string]bool), sender: prev.sender}
+	for _, rule := range rules {
+		// checked in validate
+		st, _ := newAlertState(rule)
+		i := slices.IndexFunc(prev.rules, func(old *alertState) bool {
+			return alertRuleEqual(old.rule, st.rule)
+		})
+		if i >= 0 {
+			st = prev.rules[i]
+		}
+		rv.rules = append(rv.rules, st)
+	}
+	return rv
+}
+
+// alertRuleEqual reports whether rules are the same
+func alertRuleEqual(a, b AlertRule) bool {
+	return a.Name == b.Name && a.Root == b.Root && a.Glob == b.Glob && a.Pattern == b.Pattern &&
+		a.Expr == b.Expr && a.Threshold == b.Threshold && a.Window == b.Window &&
+		a.Cooldown == b.Cooldown && slices.Equal(a.Webhooks, b.Webhooks)
+}
+
+// channelRules returns alert rules matching channel
+func (ts *TailService) channelRules(a *alerter, channel string) []*alertState {
+	attr, ok := ts.index[channel]
+	if !ok || attr.Compressed != "" {
+		// compressed file can't grow
+		return nil
+	}
+	root, rel, _ := ts.channelRoot(channel)
+	var rv []*alertState
+	for _, st := range a.rules {
+		if (st.rule.Root == "" || st.rule.Root == root.Name) && MatchGlob(st.rule.Glob, rel) {
+			rv = append(rv, st)
+		}
+	}
+	return rv
+}
+
+// match counts line match and returns alert state change if any
+func (st *alertState) match(msg *TailMessage, now time.Time) *AlertEvent {
+	if !st.re.MatchString(msg.Data) || (st.expr != nil && !exprMatches(st.expr, msg.Fields)) {
+		return nil
+	}
+	st.hits = append(st.hits, now)
+	if len(st.hits) > maxAlertHits {
+		st.hits = st.hits[len(st.hits)-maxAlertHits:]
+	}
+	st.file, st.line = msg.Channel, msg.Data
+	return st.check(now)
+}
+
+// check drops hits out of window and returns alert state change if any
+func (st *alertState) check(now time.Time) *AlertEvent {
+	since := now.Add(-st.rule.Window)
+	i := 0
+	for i < len(st.hits) && !st.hits[i].After(since) {
+		i++
+	}
+	st.hits = st.hits[i:]
+	count := len(st.hits)
+	switch {
+	case !st.firing && count >= st.rule.Threshold && (st.fired.IsZero() || now.Sub(st.fired) >= st.rule.Cooldown):
+		st.firing, st.fired = true, now
+		return st.event(AlertFiring, now)
+	case st.firing && count < st.rule.Threshold:
+		st.firing = false
+		return st.event(AlertResolved, now)
+	}
+	return nil
+}
+
+// event returns alert state
+func (st *alertState) event(state string, now time.Time) *AlertEvent {
+	return &AlertEvent{
+		Rule:      st.rule.Name,
+		State:     state,
+		Count:     len(st.hits),
+		Threshold: st.rule.Threshold,
+		File:      st.file,
+		Line:      st.line,
+		Time:      now,
+	}
+}
+
+// alertSync pins tail workers of all indexed files matched by alert rules
+func (h *Hub) alertSync() {
+	for channel := range h.alerts.pinned {
+		if _, ok := h.workers.index[channel]; !ok {
+			h.alertPin(channel)
+		}
+	}
+	for channel := range h.workers.index {
+		h.alertPin(channel)
+	}
+}
+
+// alertPin starts or stops pinned tail worker of channel according to alert rules
+func (h *Hub) alertPin(channel string) {
+	rules := h.workers.channelRules(h.alerts, channel)
+	pin, ok := h.alerts.pinned[channel]
+	if len(rules) == 0 {
+		delete(h.alerts.skipped, channel)
+		if ok {
+			delete(h.alerts.pinned, channel)
+			if h.stats[channel] == 0 {
+				h.workers.WorkerStop(channel)
+			}
+		}
+		return
+	}
+	if ok {
+		pin.rules = rules
+		return
+	}
+	if limit := h.workers.Config.AlertMaxPins; limit > 0 && len(h.alerts.pinned) >= limit {
+		if !h.alerts.skipped[channel] {
+			h.alerts.skipped[channel] = true
+			h.log.Info("Alert worker limit reached, channel skipped", "channel", channel, "limit", limit)
+		}
+		return
+	}
+	delete(h.alerts.skipped, channel)
+	if !h.workers.WorkerExists(channel) {
+		if err := h.tailerStart(channel); err != nil {
+			h.log.Error(err, "Alert worker create error", "channel", channel)
+			return
+		}
+	}
+	h.alerts.pinned[channel] = &pinnedChannel{rules: rules, from: h.workers.index[channel].Size}
+	h.log.Info("Alert worker pinned", "channel", channel)
+}
+
+// alertStop stops pinned tail workers
+func (h *Hub) alertStop() {
+	for channel := range h.alerts.pinned {
+		h.workers.WorkerStop(channel)
+	}
+}
+
+// alertLine evaluates alert rules of pinned channel for tailed line
+func (h *Hub) alertLine(msg *TailMessage) {
+	pin, ok := h.alerts.pinned[msg.Channel]
+	if !ok || msg.Type != "log" {
+		return
+	}
+	if msg.Offset < pin.last {
+		// file truncated
+		pin.from = 0
+	}
+	pin.last = msg.Offset
+	if msg.Offset <= pin.from {
+		// line existed before pin
+		return
+	}
+	now := time.Now()
+	for _, st := range pin.rules {
+		if ev := st.match(msg, now); ev != nil {
+			h.alertNotify(st, ev)
+		}
+	}
+}
+
+// alertTick checks alert windows and cooldowns
+func (h *Hub) alertTick(now time.Time) {
+	for _, st := range h.alerts.rules {
+		if ev := st.check(now); ev != nil {
+			h.alertNotify(st, ev)
+		}
+	}
+}
+
+// alertNotify sends alert to webhooks and fired alerts to clients
+func (h *Hub) alertNotify(st *alertState, ev *AlertEvent) {
+	h.log.Info("Alert", "rule", ev.Rule, "state", ev.State, "count", ev.Count)
+	h.workers.metrics.Add(metricAlerts, 1, "rule", ev.Rule, "state", ev.State)
+	body, _ := json.Marshal(ev)
+	urls := st.rule.Webhooks
+	if len(urls) == 0 {
+		urls = h.workers.Config.AlertWebhooks
+	}
+	if len(urls) > 0 {
+		h.alerts.sender.enqueue(webhookJob{urls: urls, body: body, retries: h.workers.Config.AlertRetries})
+	}
+	if ev.State != AlertFiring {
+		return
+	}
+	data, _ := json.Marshal(AlertMessage{Type: "alert", Data: ev})
+	for client := range h.clients {
+		h.send(client, data)
+	}
+}
+
+// newWebhookSender creates webhook sender
+func newWebhookSender(logger logr.Logger, metrics *Metrics) *webhookSender {
+	metrics.Describe(metricAlerts, MetricCounter, "Alert state changes")
+	metrics.Describe(metricWebhookErrors, MetricCounter, "Alert webhook deliveries failed after all retries")
+	return &webhookSender{
+		log:     logger,
+		client:  &http.Client{Timeout: webhookWait},
+		queue:   make(chan webhookJob, webhookQueue),
+		urls:    make(map[string]chan webhookJob),
+		delay:   time.Second,
+		metrics: metrics,
+	}
+}
+
+// enqueue adds job to delivery queue, job is dropped if queue is full
+func (s *webhookSender) enqueue(job webhookJob) {
+	select {
+	case s.queue <- job:
+	default:
+		s.log.Info("Alert webhook queue is full, event dropped")
+		s.metrics.Add(metricWebhookErrors, float64(len(job.urls)))
+	}
+}
+
+// run dispatches queued jobs to per URL queues until quit
+func (s *webhookSender) run(quit <-chan struct{}) {
+	for {
+		select {
+		case job := <-s.queue:
+			for _, u := range job.urls {
+				q, ok := s.urls[u]
+				if !ok {
+					q = make(chan webhookJob, webhookQueue)
+					s.urls[u] = q
+					go s.deliver(quit, u, q)
+				}
+				select {
+				case q <- webhookJob{urls: []string{u}, body: job.body, retries: job.retries}:
+				default:
+					s.log.Info("Alert webhook queue is full, event dropped", "url", u)
+					s.metrics.Add(metricWebhookErrors, 1)
+				}
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// deliver posts jobs of URL queue until quit
+func (s *webhookSender) deliver(quit <-chan struct{}, u string, queue chan webhookJob) {
+	for {
+		select {
+		case job := <-queue:
+			if err := s.post(quit, u, job.body, job.retries); err != nil {
+				s.log.Error(err, "Alert webhook error", "url", u)
+				s.metrics.Add(metricWebhookErrors, 1)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// post sends body to webhook with retries
+func (s *webhookSender) post(quit <-chan struct{}, u string, body []byte, retries int) error {
+	delay := s.delay
+	for i := 0; ; i++ {
+		err := s.postOnce(u, body)
+		if err == nil || i >= retries {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-quit:
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// postOnce sends body to webhook
+func (s *webhookSender) postOnce(u string, body []byte) error {
+	resp, err := s.client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status %d", resp.StatusCode)
+	}
+	return nil
+}
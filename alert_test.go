@@ -0,0 +1,81 @@
+package webtail
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertState(t *testing.T) {
+	st, err := newAlertState(AlertRule{Name: "err", Pattern: "ERROR", Expr: `code >= 500`, Threshold: 2, Window: time.Minute, Cooldown: 5 * time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	line := func(data string, code float64) *TailMessage {
+		return &TailMessage{Channel: "app.log", Data: data, Fields: map[string]interface{}{"code": code}}
+	}
+	assert.Nil(t, st.match(line("ERROR db", 502), now))
+	assert.Nil(t, st.match(line("INFO ok", 502), now), "pattern mismatch")
+	assert.Nil(t, st.match(line("ERROR auth", 401), now), "expr mismatch")
+	ev := st.match(line("ERROR db", 503), now.Add(time.Second))
+	require.NotNil(t, ev)
+	assert.Equal(t, AlertEvent{Rule: "err", State: AlertFiring, Count: 2, Threshold: 2, File: "app.log", Line: "ERROR db", Time: now.Add(time.Second)}, *ev)
+
+	assert.Nil(t, st.check(now.Add(30*time.Second)))
+	ev = st.check(now.Add(2 * time.Minute))
+	require.NotNil(t, ev)
+	assert.Equal(t, AlertResolved, ev.State)
+
+	// cooldown
+	later := now.Add(3 * time.Minute)
+	st.match(line("ERROR db", 500), later)
+	assert.Nil(t, st.match(line("ERROR db", 500), later))
+	ev = st.check(now.Add(6*time.Minute + time.Second))
+	assert.Nil(t, ev, "hits are out of window")
+	after := now.Add(7 * time.Minute)
+	st.match(line("ERROR db", 500), after)
+	ev = st.match(line("ERROR db", 500), after)
+	require.NotNil(t, ev, "fires again after cooldown")
+	assert.Equal(t, AlertFiring, ev.State)
+}
+
+func TestAlertRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yml")
+	err := os.WriteFile(file, []byte(`
+alert:
+  - name: errors
+    glob: "*.log"
+    pattern: ERROR
+    window: 5m
+    webhooks: [ftp://host]
+`), 0o600)
+	require.NoError(t, err)
+	_, err = LoadRules(file)
+	assert.ErrorContains(t, err, "bad webhook")
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, `{"rule":"x"}`, string(body))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	s := newWebhookSender(logr.Discard(), NewMetrics())
+	s.delay = time.Millisecond
+	quit := make(chan struct{})
+	assert.Error(t, s.post(quit, srv.URL, []byte(`{"rule":"x"}`), 1))
+	assert.NoError(t, s.post(quit, srv.URL, []byte(`{"rule":"x"}`), 1))
+	assert.Equal(t, int32(3), calls.Load())
+}
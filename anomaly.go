@@ -0,0 +1,169 @@
+package webtail
+
+// This file holds line rate anomaly detection of tailed files
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Anomaly kinds
+const (
+	AnomalySpike   = "spike"   // rate is above baseline by factor
+	AnomalyDrop    = "drop"    // rate is below baseline by factor
+	AnomalySilence = "silence" // growing file has no lines
+	AnomalyNormal  = "normal"  // rate is back to baseline
+)
+
+const (
+	// intervals used for baseline before detection starts
+	anomalyWarmup = 3
+	// baseline smoothing factor
+	anomalyAlpha = 0.2
+)
+
+// Metric names
+const (
+	metricLineRate     = "webtail_line_rate"
+	metricLineBaseline = "webtail_line_rate_baseline"
+	metricAnomaly      = "webtail_anomaly"
+	metricAnomalies    = "webtail_anomalies_total"
+)
+
+// AnomalyEvent holds line rate anomaly of file
+type AnomalyEvent struct {
+	File     string    `json:"file"`
+	Kind     string    `json:"kind"`
+	Rate     float64   `json:"rate"`     // lines per interval
+	Baseline float64   `json:"baseline"` // expected lines per interval
+	Interval int       `json:"interval"` // interval in seconds
+	Time     time.Time `json:"time"`
+}
+
+// AnomalyMessage holds outgoing anomaly
+type AnomalyMessage struct {
+	Type string        `json:"type"`
+	Data *AnomalyEvent `json:"data"`
+}
+
+// lineRate holds line rate statistics of tail worker
+type lineRate struct {
+	// lines in current interval
+	count int
+	// current interval start
+	start time.Time
+	// finished intervals
+	samples int
+	// rolling average of lines per interval
+	baseline float64
+	// consecutive empty intervals and baseline before them
+	empty  int
+	active float64
+	// current anomaly kind, "" if none
+	kind string
+}
+
+// AnomalyTick closes rate intervals of tail workers and returns anomaly state changes
+func (ts *TailService) AnomalyTick(now time.Time) []*AnomalyEvent {
+	cfg := ts.Config
+	interval := time.Duration(cfg.AnomalyInterval) * time.Second
+	if cfg.AnomalyFactor <= 0 || interval <= 0 {
+		return nil
+	}
+	var rv []*AnomalyEvent
+	for channel, w := range ts.workers {
+		if attr, ok := ts.index[channel]; channel == "" || !ok || attr.Compressed != "" {
+			// compressed file can't grow
+			continue
+		}
+		r := &w.Rate
+		if r.start.IsZero() {
+			r.start = now
+			continue
+		}
+		if now.Sub(r.start) < interval {
+			continue
+		}
+		sample := float64(r.count)
+		r.count, r.start = 0, now
+		r.samples++
+		if r.samples == 1 {
+			// the first interval holds lines read on worker start
+			continue
+		}
+		kind := ""
+		if r.samples > anomalyWarmup+1 {
+			kind = r.classify(sample, cfg.AnomalyFactor, cfg.AnomalySilence)
+		}
+		if r.samples == 2 {
+			r.baseline = sample
+		} else {
+			r.baseline += anomalyAlpha * (sample - r.baseline)
+		}
+		ts.metrics.Set(metricLineRate, sample, "channel", channel)
+		ts.metrics.Set(metricLineBaseline, math.Round(r.baseline*10)/10, "channel", channel)
+		if kind == r.kind {
+			continue
+		}
+		ev := &AnomalyEvent{File: channel, Kind: kind, Rate: sample, Baseline: math.Round(r.baseline*10) / 10, Interval: cfg.AnomalyInterval, Time: now}
+		if r.kind != "" {
+			ts.metrics.Delete(metricAnomaly, "channel", channel, "kind", r.kind)
+		}
+		if kind == "" {
+			ev.Kind = AnomalyNormal
+		} else {
+			ts.metrics.Set(metricAnomaly, 1, "channel", channel, "kind", kind)
+			ts.metrics.Add(metricAnomalies, 1, "kind", kind)
+		}
+		r.kind = kind
+		rv = append(rv, ev)
+	}
+	return rv
+}
+
+// classify returns anomaly kind of interval sample, "" if sample is normal
+func (r *lineRate) classify(sample, factor float64, silence int) string {
+	if sample == 0 {
+		if r.empty == 0 {
+			r.active = r.baseline
+		}
+		r.empty++
+		if silence > 0 && r.empty >= silence && r.active >= 1 {
+			return AnomalySilence
+		}
+		if r.kind == AnomalySilence {
+			return r.kind
+		}
+		return ""
+	}
+	r.empty = 0
+	switch {
+	case sample > factor*max(r.baseline, 1):
+		return AnomalySpike
+	case sample*factor < r.baseline:
+		return AnomalyDrop
+	}
+	return ""
+}
+
+// deleteRateMetrics removes line rate metrics of channel
+func (ts *TailService) deleteRateMetrics(channel string, w *TailAttr) {
+	ts.metrics.Delete(metricLineRate, "channel", channel)
+	ts.metrics.Delete(metricLineBaseline, "channel", channel)
+	if w.Rate.kind != "" {
+		ts.metrics.Delete(metricAnomaly, "channel", channel, "kind", w.Rate.kind)
+	}
+}
+
+// anomalyNotify sends anomaly to clients and alert webhooks
+func (h *Hub) anomalyNotify(ev *AnomalyEvent) {
+	h.log.Info("Anomaly", "file", ev.File, "kind", ev.Kind, "rate", ev.Rate, "baseline", ev.Baseline)
+	data, _ := json.Marshal(AnomalyMessage{Type: "anomaly", Data: ev})
+	if urls := h.workers.Config.AlertWebhooks; len(urls) > 0 {
+		h.alerts.sender.enqueue(webhookJob{urls: urls, body: data, retries: h.workers.Config.AlertRetries})
+	}
+	for client := range h.clients {
+		h.send(client, data)
+	}
+}
@@ -0,0 +1,31 @@
+package webtail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnomalyTick(t *testing.T) {
+	cfg := &Config{Root: "testdata", Lines: 10, AnomalyInterval: 60, AnomalyFactor: 3, AnomalySilence: 2}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+	now := time.Now()
+	ts.index["a.log"] = &IndexItemAttr{Size: 1}
+	ts.workers["a.log"] = &TailAttr{Lines: cfg.Lines, Rate: lineRate{start: now}}
+
+	kinds := []string{}
+	for _, count := range []int{100, 10, 10, 10, 12, 50, 10, 0, 0, 0, 10, 2} {
+		for i := 0; i < count; i++ {
+			ts.TailerAppend("a.log", []byte("line"), nil)
+		}
+		now = now.Add(time.Minute)
+		for _, ev := range ts.AnomalyTick(now) {
+			kinds = append(kinds, ev.Kind)
+		}
+	}
+	assert.Equal(t, []string{AnomalySpike, AnomalyNormal, AnomalySilence, AnomalyNormal, AnomalyDrop}, kinds)
+}
@@ -0,0 +1,191 @@
+package webtail
+
+// This file holds audit log of user actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Audit event types
+const (
+	AuditAttach  = "attach"
+	AuditDetach  = "detach"
+	AuditSearch  = "search"
+	AuditHistory = "history"
+)
+
+// metricAuditErrors is an audit write errors counter name
+const metricAuditErrors = "webtail_audit_errors_total"
+
+// AuditEvent holds audit log record
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	User     string    `json:"user,omitempty"`
+	Groups   []string  `json:"groups,omitempty"`
+	Addr     string    `json:"addr,omitempty"`     // client IP and port, IP forwarded by trusted proxy
+	Channel  string    `json:"channel,omitempty"`  // file or search glob
+	Query    string    `json:"query,omitempty"`    // search pattern
+	Duration float64   `json:"duration,omitempty"` // seconds since attach, for detach event
+	Error    string    `json:"error,omitempty"`
+}
+
+// auditLog writes audit events as json lines to file and syslog
+// File is rotated as file.1, file.2 ... when its size exceeds limit
+type auditLog struct {
+	log     logr.Logger
+	metrics *Metrics
+	path    string
+	maxSize int64 // 0 - no rotation
+	keep    int   // rotated files to keep
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	syslog  io.WriteCloser
+	// events recorded after Close are dropped
+	closed bool
+}
+
+// newAuditLog opens audit log, returns nil if audit is disabled
+func newAuditLog(log logr.Logger, cfg *Config, metrics *Metrics) (*auditLog, error) {
+	if cfg.AuditFile == "" && !cfg.AuditSyslog {
+		return nil, nil
+	}
+	metrics.Describe(metricAuditErrors, MetricCounter, "Audit log write errors")
+	al := &auditLog{log: log, metrics: metrics, path: cfg.AuditFile, maxSize: cfg.AuditSize, keep: cfg.AuditKeep}
+	if al.path != "" {
+		if err := al.open(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AuditSyslog {
+		w, err := newSyslogWriter("webtail")
+		if err != nil {
+			al.Close()
+			return nil, fmt.Errorf("audit syslog: %w", err)
+		}
+		al.syslog = w
+	}
+	return al, nil
+}
+
+// open opens audit file for appending
+func (al *auditLog) open() error {
+	f, err := os.OpenFile(al.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit file: %w", err)
+	}
+	al.file, al.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames file to file.1 shifting older files, the oldest one is removed
+func (al *auditLog) rotate() error {
+	if err := al.file.Close(); err != nil {
+		return err
+	}
+	al.file = nil
+	if al.keep > 0 {
+		for i := al.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", al.path, i), fmt.Sprintf("%s.%d", al.path, i+1))
+		}
+		if err := os.Rename(al.path, al.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(al.path); err != nil {
+		return err
+	}
+	return al.open()
+}
+
+// Record writes audit event, Time is set if zero
+// Record is safe for concurrent use and does nothing if audit is disabled or closed
+func (al *auditLog) Record(event AuditEvent) {
+	if al == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	data, _ := json.Marshal(event)
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return
+	}
+	if err := al.write(data); err != nil {
+		al.log.Error(err, "Audit write")
+		al.metrics.Add(metricAuditErrors, 1)
+	}
+}
+
+// write writes json line to file and syslog, lock must be held
+func (al *auditLog) write(data []byte) error {
+	var err error
+	if al.path != "" {
+		err = al.writeFile(append(data, newline...))
+	}
+	if al.syslog != nil {
+		if _, e := al.syslog.Write(data); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// writeFile appends line to file, rotating it if needed
+func (al *auditLog) writeFile(line []byte) error {
+	if al.file == nil {
+		// previous rotation failed
+		if err := al.open(); err != nil {
+			return err
+		}
+	}
+	if al.maxSize > 0 && al.size > 0 && al.size+int64(len(line)) > al.maxSize {
+		if err := al.rotate(); err != nil {
+			return fmt.Errorf("audit rotate: %w", err)
+		}
+	}
+	n, err := al.file.Write(line)
+	al.size += int64(n)
+	return err
+}
+
+// Close closes audit file and syslog connection
+func (al *auditLog) Close() error {
+	if al == nil {
+		return nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.closed = true
+	var err error
+	if al.file != nil {
+		err = al.file.Close()
+		al.file = nil
+	}
+	if al.syslog != nil {
+		if e := al.syslog.Close(); e != nil && err == nil {
+			err = e
+		}
+		al.syslog = nil
+	}
+	return err
+}
+
+// clientEvent returns audit event of client action
+func clientEvent(event string, client *Client, channel string) AuditEvent {
+	return AuditEvent{Event: event, User: client.user, Groups: client.groups, Addr: client.addr, Channel: channel}
+}
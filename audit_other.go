@@ -0,0 +1,13 @@
+//go:build !unix
+
+package webtail
+
+import (
+	"errors"
+	"io"
+)
+
+// newSyslogWriter returns error, syslog is not supported
+func newSyslogWriter(_ string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported")
+}
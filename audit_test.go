@@ -0,0 +1,41 @@
+package webtail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := newAuditLog(logr.Discard(), &Config{AuditFile: path, AuditSize: 150, AuditKeep: 2}, NewMetrics())
+	require.NoError(t, err)
+	client := &Client{user: "alice", groups: []string{"dev"}, addr: "10.0.0.1:5000"}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		event := clientEvent(AuditAttach, client, "app.log")
+		event.Time = at
+		al.Record(event)
+	}
+	require.NoError(t, al.Close())
+	// hub may record events after Close
+	al.Record(clientEvent(AuditDetach, client, "app.log"))
+
+	line := `{"time":"2024-01-02T03:04:05Z","event":"attach","user":"alice","groups":["dev"],"addr":"10.0.0.1:5000","channel":"app.log"}` + newline
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, line, string(data), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "the oldest file is removed")
+
+	var disabled *auditLog
+	disabled.Record(AuditEvent{Event: AuditSearch})
+	assert.NoError(t, disabled.Close())
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package webtail
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// newSyslogWriter connects to local syslog
+func newSyslogWriter(tag string) (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+}
@@ -28,7 +28,8 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// It holds filter expression (escaped in json) and the rest of message.
+	maxMessageSize = 2*maxExprLen + 4096
 )
 
 // Client is a middleman between the websocket connection and the hub.
@@ -40,6 +41,12 @@ type Client struct {
 	send chan []byte
 
 	log logr.Logger
+
+	// user name and groups from auth proxy headers
+	user   string
+	groups []string
+	// remote address or address forwarded by trusted proxy
+	addr string
 }
 
 const (
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,6 +22,8 @@ import (
 type Config struct {
 	Listen string `long:"listen"      default:":8080"   description:"Http listen address"`
 	HTML   string `long:"html"        default:""        description:"Serve pages from this path"`
+	Admin  bool   `long:"admin"       description:"Enable admin API (POST /api/reload, only rules file is reloaded)"`
+	Token  string `long:"admin_token" env:"ADMIN_TOKEN" description:"Bearer token of admin API requests (required by --admin)"`
 
 	Logger logger.Config  `group:"Logging Options" namespace:"log" env-namespace:"LOG"`
 	Tail   webtail.Config `group:"Webtail Options"`
@@ -42,6 +46,10 @@ func Run(exitFunc func(code int)) {
 	if err != nil {
 		return
 	}
+	if cfg.Admin && cfg.Token == "" {
+		err = errors.New("admin API requires admin_token")
+		return
+	}
 	log := logger.New(cfg.Logger, nil)
 	log.Info("WebTail. Tail (log)files via web.", "v", version)
 
@@ -54,10 +62,40 @@ func Run(exitFunc func(code int)) {
 	http.Handle("/", webtail.FileServer(cfg.HTML))
 	http.Handle("/tail", wt)
 	http.HandleFunc("/api/stats", stats_api.Handler)
+	http.Handle("/metrics", wt.Metrics())
+	http.HandleFunc("/api/index", wt.ServeIndex)
+	http.HandleFunc("/api/search", wt.ServeSearch)
+	http.HandleFunc("/api/health/files", wt.ServeHealth)
+	if cfg.Admin {
+		http.HandleFunc("/api/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "POST required", http.StatusMethodNotAllowed)
+				return
+			}
+			if !adminAllowed(r, cfg.Token) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if err := reload(wt); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
 	log.Info("Listen", "addr", cfg.Listen)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go wt.Run()
+	go func() {
+		for range hup {
+			if err := reload(wt); err != nil {
+				log.Error(err, "Config reload")
+			}
+		}
+	}()
 	go func() {
 		// service connections
 		s := &http.Server{
@@ -74,3 +112,18 @@ func Run(exitFunc func(code int)) {
 	wt.Close()
 	log.Info("Server stopped")
 }
+
+// adminAllowed checks admin API request token
+func adminAllowed(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// reload reads config again and applies it to service
+// Flags and environment are the same as on start, so only rules file changes are applied
+func reload(wt *webtail.Service) error {
+	var cfg Config
+	if err := config.Open(&cfg); err != nil {
+		return err
+	}
+	return wt.Reload(&cfg.Tail)
+}
@@ -0,0 +1,167 @@
+package webtail
+
+// This file holds compressed log file reading
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nxadm/tail"
+)
+
+// ErrUnsupportedCompression returned when file compression format can't be read
+var ErrUnsupportedCompression = errors.New("unsupported compression")
+
+// logReader holds decompressed file stream
+type logReader struct {
+	io.Reader
+	// source file
+	file    *os.File
+	closers []func() error
+}
+
+// Close closes decompressor and file
+func (r *logReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if e := r.closers[i](); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// openLog opens file for reading, compressed file is decompressed
+// Returns compression format, empty for plain file
+func openLog(filename string) (io.ReadCloser, string, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	rv := &logReader{file: fh, closers: []func() error{fh.Close}}
+	br := bufio.NewReader(fh)
+	head, _ := br.Peek(sniffSize)
+	format := compression(head)
+	switch format {
+	case "":
+		rv.Reader = br
+	case CompressGzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			fh.Close()
+			return nil, format, err
+		}
+		rv.Reader = zr
+		rv.closers = append(rv.closers, zr.Close)
+	case CompressZstd:
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			fh.Close()
+			return nil, format, err
+		}
+		rv.Reader = zr
+		rv.closers = append(rv.closers, func() error { zr.Close(); return nil })
+	case CompressBzip2:
+		rv.Reader = bzip2.NewReader(br)
+	default:
+		fh.Close()
+		return nil, format, fmt.Errorf("%w: %s", ErrUnsupportedCompression, format)
+	}
+	return rv, format, nil
+}
+
+// isCompressed checks if file is compressed
+// ErrUnsupportedCompression is returned if file can't be decompressed by openLog
+func isCompressed(filename string) (bool, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	head := make([]byte, sniffSize)
+	n, _ := io.ReadFull(fh, head)
+	switch format := compression(head[:n]); format {
+	case "":
+		return false, nil
+	case CompressGzip, CompressZstd, CompressBzip2:
+		return true, nil
+	default:
+		return true, fmt.Errorf("%w: %s", ErrUnsupportedCompression, format)
+	}
+}
+
+// staticTail reads the whole file without following, used for compressed files
+type staticTail struct {
+	lines chan *tail.Line
+	quit  chan struct{}
+	err   error
+}
+
+// newStaticTail starts reading of last set.Lines lines of file
+func newStaticTail(filename string, set TailSettings) *staticTail {
+	st := &staticTail{lines: make(chan *tail.Line), quit: make(chan struct{})}
+	go st.run(filename, set)
+	return st
+}
+
+func (st *staticTail) run(filename string, set TailSettings) {
+	defer close(st.lines)
+	r, _, err := openLog(filename)
+	if err != nil {
+		st.err = err
+		return
+	}
+	defer r.Close()
+	var (
+		buf  []*tail.Line
+		size int64
+	)
+	// lines are split by tail worker after redaction
+	err = scanLines(r, 0, 0, func(_, end int64, text string) bool {
+		buf = append(buf, &tail.Line{Text: text, SeekInfo: tail.SeekInfo{Offset: end}})
+		size += int64(len(text))
+		for len(buf) > max(set.Lines, 1) || (set.Bytes > 0 && size > set.Bytes && len(buf) > 1) {
+			size -= int64(len(buf[0].Text))
+			buf = buf[1:]
+		}
+		select {
+		case <-st.quit:
+			return false
+		default:
+			return true
+		}
+	})
+	if err != nil {
+		st.err = err
+		return
+	}
+	for _, line := range buf {
+		select {
+		case st.lines <- line:
+		case <-st.quit:
+			return
+		}
+	}
+}
+
+// Lines returns lines channel
+func (st *staticTail) Lines() <-chan *tail.Line {
+	return st.lines
+}
+
+// Err returns read error, valid after lines channel is closed
+func (st *staticTail) Err() error {
+	return st.err
+}
+
+// Stop stops reading
+func (st *staticTail) Stop() error {
+	close(st.quit)
+	return nil
+}
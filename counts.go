@@ -0,0 +1,67 @@
+package webtail
+
+// This file holds rolling counters with a bucket per time step
+
+import "time"
+
+// rollingCounts holds counts of the last len(counts) time steps
+type rollingCounts struct {
+	counts []uint64
+	// bucket duration
+	step time.Duration
+	// step number of the last count
+	last int64
+}
+
+// newRollingCounts creates counters for given steps
+func newRollingCounts(steps int, step time.Duration) rollingCounts {
+	return rollingCounts{counts: make([]uint64, steps), step: step}
+}
+
+// newMinuteCounts creates counters for given minutes
+func newMinuteCounts(minutes int) rollingCounts {
+	return newRollingCounts(minutes, time.Minute)
+}
+
+// add counts event at now
+func (c *rollingCounts) add(now time.Time) {
+	c.addN(now, 1)
+}
+
+// addN adds n to count of now
+func (c *rollingCounts) addN(now time.Time, n uint64) {
+	c.advance(now)
+	c.counts[c.last%int64(len(c.counts))] += n
+}
+
+// advance clears buckets of steps passed since the last count
+func (c *rollingCounts) advance(now time.Time) {
+	cur := now.UnixNano() / int64(c.step)
+	size := int64(len(c.counts))
+	for i := int64(1); i <= min(cur-c.last, size); i++ {
+		c.counts[(c.last+i)%size] = 0
+	}
+	c.last = max(c.last, cur)
+}
+
+// count returns events count within window
+func (c *rollingCounts) count(window time.Duration, now time.Time) uint64 {
+	c.advance(now)
+	size := int64(len(c.counts))
+	var rv uint64
+	for i := int64(0); i < int64((window+c.step-1)/c.step) && i < size; i++ {
+		rv += c.counts[(c.last-i+size)%size]
+	}
+	return rv
+}
+
+// values returns counts from the oldest step to the current one
+func (c *rollingCounts) values(now time.Time) []uint64 {
+	c.advance(now)
+	size := int64(len(c.counts))
+	rv := make([]uint64, size)
+	for i := range rv {
+		rv[i] = c.counts[(c.last+1+int64(i))%size]
+	}
+	return rv
+}
@@ -0,0 +1,78 @@
+package webtail
+
+// This file holds suppression of repeated channel lines
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Dedup modes
+const (
+	DedupExact  = "exact"  // lines are equal
+	DedupMasked = "masked" // lines are equal after masking numbers and timestamps
+)
+
+// dedupNumbers matches numbers, timestamps are masked as sequences of numbers
+var dedupNumbers = regexp.MustCompile(`\d+`)
+
+// dedupState holds the last line of channel with repeat count
+type dedupState struct {
+	key string
+	msg TailMessage
+	// buffer sequence number of the line
+	seq uint64
+}
+
+// checkDedup checks dedup mode
+func checkDedup(mode string) error {
+	switch mode {
+	case "", DedupExact, DedupMasked:
+		return nil
+	}
+	return fmt.Errorf("unknown dedup mode %q", mode)
+}
+
+// dedupKey returns line key to compare with the previous one
+func dedupKey(mode, line string) string {
+	if mode == DedupMasked {
+		return dedupNumbers.ReplaceAllLiteralString(line, "#")
+	}
+	return line
+}
+
+// TailerRepeat checks if log line repeats the last buffered line of channel
+// If so, repeat count of buffered line is increased and repeat message is returned
+func (ts *TailService) TailerRepeat(msg *TailMessage) ([]byte, bool) {
+	w, ok := ts.workers[msg.Channel]
+	if !ok || w.Settings.Dedup == "" || msg.Type != "log" {
+		return nil, false
+	}
+	key := dedupKey(w.Settings.Dedup, msg.Data)
+	last := w.dedup
+	if last == nil || last.key != key || last.seq != w.Seq || len(w.Buffer) == 0 {
+		w.dedup = &dedupState{key: key, msg: *msg, seq: w.Seq + 1}
+		w.dedup.msg.Fields = nil
+		return nil, false
+	}
+	// collapsed line is counted as appended one
+	w.Rate.count++
+	last.msg.Repeat = max(last.msg.Repeat, 1) + 1
+	last.msg.Offset = msg.Offset
+	data, _ := json.Marshal(last.msg)
+	i := len(w.Buffer) - 1
+	delta := len(data) - len(w.Buffer[i])
+	w.Buffer[i] = data
+	if prev, ok := w.Raw[last.seq]; ok {
+		// original line for clients exempt from redaction
+		orig := last.msg
+		orig.Data = orig.raw
+		raw, _ := json.Marshal(orig)
+		w.Raw[last.seq] = raw
+		delta += len(raw) - len(prev)
+	}
+	ts.setBufferSize(msg.Channel, w, w.BufferSize+int64(delta))
+	rv, _ := json.Marshal(TailMessage{Type: "repeat", Channel: msg.Channel, Offset: msg.Offset, Repeat: last.msg.Repeat})
+	return rv, true
+}
@@ -0,0 +1,62 @@
+package webtail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailerRepeat(t *testing.T) {
+	cfg := &Config{Root: "testdata", Lines: 10}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+	ts.workers["a"] = &TailAttr{Lines: cfg.Lines, Settings: TailSettings{Dedup: DedupMasked}}
+
+	repeats := []int{}
+	for i, line := range []string{"retry 1 at 10:00:01", "retry 2 at 10:00:02", "retry 3 at 10:00:03", "done", "done"} {
+		msg := &TailMessage{Type: "log", Channel: "a", Data: line, Offset: int64(i + 1)}
+		if rep, ok := ts.TailerRepeat(msg); ok {
+			out := TailMessage{}
+			require.NoError(t, json.Unmarshal(rep, &out))
+			repeats = append(repeats, out.Repeat)
+			continue
+		}
+		data, _ := json.Marshal(msg)
+		ts.TailerAppend("a", data, nil)
+	}
+	assert.Equal(t, []int{2, 3, 2}, repeats)
+	buf := bufferStrings(ts.TailerBuffer("a"))
+	assert.Equal(t, []string{
+		`{"type":"log","channel":"a","data":"retry 1 at 10:00:01","offset":3,"repeat":3}`,
+		`{"type":"log","channel":"a","data":"done","offset":5,"repeat":2}`,
+	}, buf)
+	assert.Equal(t, int64(len(buf[0])+len(buf[1])), ts.workers["a"].BufferSize, "buffer size follows replaced lines")
+	assert.Equal(t, 5, ts.workers["a"].Rate.count, "repeats are counted in line rate")
+
+	ts.workers["b"] = &TailAttr{Lines: cfg.Lines, Settings: TailSettings{Dedup: DedupExact}}
+	for range 2 {
+		msg := &TailMessage{Type: "log", Channel: "b", Data: "token=***", raw: "token=secret"}
+		if _, ok := ts.TailerRepeat(msg); ok {
+			continue
+		}
+		data, _ := json.Marshal(msg)
+		orig := *msg
+		orig.Data = msg.raw
+		raw, _ := json.Marshal(orig)
+		ts.TailerAppend("b", data, raw)
+	}
+	assert.Equal(t, `{"type":"log","channel":"b","data":"token=secret","repeat":2}`, string(ts.bufferItem("b", 1, true)), "original line keeps repeat count")
+	assert.Equal(t, `{"type":"log","channel":"b","data":"token=***","repeat":2}`, string(ts.bufferItem("b", 1, false)))
+	assert.Equal(t, int64(len(ts.bufferItem("b", 1, true))+len(ts.bufferItem("b", 1, false))), ts.workers["b"].BufferSize, "original is counted in buffer size")
+}
+
+func bufferStrings(buf [][]byte) []string {
+	rv := make([]string, len(buf))
+	for i, b := range buf {
+		rv[i] = string(b)
+	}
+	return rv
+}
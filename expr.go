@@ -0,0 +1,506 @@
+package webtail
+
+// This file holds filter expression language for parsed line fields
+//
+// Grammar:
+//
+//	expr    = and { "||" and }
+//	and     = not { "&&" not }
+//	not     = "!" not | cmp
+//	cmp     = operand [ op operand ]
+//	op      = "==" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "!~" | "in" | "contains"
+//	operand = "(" expr ")" | "[" [ operand { "," operand } ] "]" | field | string | number | true | false | null
+//
+// Fields are dotted names of parsed line fields, right operand of "~" must be a string.
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxExprLen is a max filter expression length
+const maxExprLen = 4096
+
+// ExprError is a filter expression parse error
+type ExprError struct {
+	Pos int // 1-based position in expression
+	Msg string
+}
+
+// Error returns error message with position
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("expr: %s at position %d", e.Msg, e.Pos)
+}
+
+// exprNode is a compiled expression node
+type exprNode interface {
+	eval(fields map[string]interface{}) interface{}
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+// token is a lexeme of expression
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// exprParser holds parser state
+type exprParser struct {
+	tokens []token
+	cur    int
+}
+
+type (
+	exprConst struct{ val interface{} }
+	exprField struct{ path []string }
+	exprList  struct{ items []exprNode }
+	exprNot   struct{ x exprNode }
+	exprAnd   struct{ x, y exprNode }
+	exprOr    struct{ x, y exprNode }
+	exprCmp   struct {
+		op   string
+		x, y exprNode
+	}
+	exprMatch struct {
+		x   exprNode
+		re  *regexp.Regexp
+		neg bool
+	}
+)
+
+// compileExpr parses filter expression
+func compileExpr(src string) (exprNode, error) {
+	if len(src) > maxExprLen {
+		return nil, &ExprError{Pos: maxExprLen + 1, Msg: "expression too long"}
+	}
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return node, nil
+}
+
+// exprMatches evaluates expression as condition
+func exprMatches(node exprNode, fields map[string]interface{}) bool {
+	return truthy(node.eval(fields))
+}
+
+// lexExpr splits expression into tokens
+func lexExpr(src string) ([]token, error) {
+	var rv []token
+	i := 0
+	for i < len(src) {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case r == '"' || r == '\'':
+			i += size
+			var sb strings.Builder
+			for {
+				if i >= len(src) {
+					return nil, &ExprError{Pos: start + 1, Msg: "unterminated string"}
+				}
+				c := src[i]
+				if c == byte(r) {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						c = '\n'
+					case 't':
+						c = '\t'
+					default:
+						c = src[i]
+					}
+				}
+				sb.WriteByte(c)
+				i++
+			}
+			rv = append(rv, token{kind: tokString, text: sb.String(), pos: start + 1})
+			continue
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9'):
+			i++
+			for i < len(src) && strings.IndexByte("0123456789.eE_", src[i]) >= 0 {
+				i++
+			}
+			rv = append(rv, token{kind: tokNumber, text: src[start:i], pos: start + 1})
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(src) {
+				r, size = utf8.DecodeRuneInString(src[i:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' && r != '-' {
+					break
+				}
+				i += size
+			}
+			rv = append(rv, token{kind: tokIdent, text: src[start:i], pos: start + 1})
+			continue
+		}
+		op := ""
+		for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "<", ">", "!", "~", "(", ")", "[", "]", ","} {
+			if strings.HasPrefix(src[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return nil, &ExprError{Pos: i + 1, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+		rv = append(rv, token{kind: tokOp, text: op, pos: i + 1})
+		i += len(op)
+	}
+	return append(rv, token{kind: tokEOF, pos: len(src) + 1}), nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.cur]
+	if t.kind != tokEOF {
+		p.cur++
+	}
+	return t
+}
+
+// accept consumes operator or keyword token
+func (p *exprParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.cur++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) errorf(t token, format string, args ...interface{}) error {
+	if t.kind == tokEOF {
+		return &ExprError{Pos: t.pos, Msg: "unexpected end of expression"}
+	}
+	return &ExprError{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var y exprNode
+		if y, err = p.parseAnd(); err == nil {
+			x = exprOr{x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	x, err := p.parseNot()
+	for err == nil && p.accept("&&") {
+		var y exprNode
+		if y, err = p.parseNot(); err == nil {
+			x = exprAnd{x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseNot()
+		return exprNot{x}, err
+	}
+	return p.parseCmp()
+}
+
+func (p *exprParser) parseCmp() (exprNode, error) {
+	x, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=", "in", "contains":
+		if t.kind == tokString || t.kind == tokNumber {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return exprCmp{op: t.text, x: x, y: y}, nil
+	case "~", "!~":
+		if t.kind != tokOp {
+			return x, nil
+		}
+		p.next()
+		rt := p.next()
+		if rt.kind != tokString {
+			return nil, p.errorf(rt, "regexp string expected")
+		}
+		re, err := regexp.Compile(rt.text)
+		if err != nil {
+			return nil, &ExprError{Pos: rt.pos, Msg: err.Error()}
+		}
+		return exprMatch{x: x, re: re, neg: t.text == "!~"}, nil
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return exprConst{t.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(t.text, "_", ""), 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad number %q", t.text)
+		}
+		return exprConst{f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return exprConst{true}, nil
+		case "false":
+			return exprConst{false}, nil
+		case "null":
+			return exprConst{nil}, nil
+		case "in", "contains":
+			return nil, p.errorf(t, "unexpected %q", t.text)
+		}
+		return exprField{strings.Split(t.text, ".")}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf(p.peek(), "%q expected", ")")
+			}
+			return x, nil
+		case "[":
+			list := exprList{}
+			if p.accept("]") {
+				return list, nil
+			}
+			for {
+				x, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, x)
+				if p.accept("]") {
+					return list, nil
+				}
+				if !p.accept(",") {
+					return nil, p.errorf(p.peek(), "%q or %q expected", ",", "]")
+				}
+			}
+		}
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+func (n exprConst) eval(map[string]interface{}) interface{} { return n.val }
+
+func (n exprField) eval(fields map[string]interface{}) interface{} {
+	var cur interface{} = fields
+	for _, k := range n.path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[k]
+	}
+	return cur
+}
+
+func (n exprList) eval(fields map[string]interface{}) interface{} {
+	rv := make([]interface{}, len(n.items))
+	for i, x := range n.items {
+		rv[i] = x.eval(fields)
+	}
+	return rv
+}
+
+func (n exprNot) eval(fields map[string]interface{}) interface{} {
+	return !truthy(n.x.eval(fields))
+}
+
+func (n exprAnd) eval(fields map[string]interface{}) interface{} {
+	return truthy(n.x.eval(fields)) && truthy(n.y.eval(fields))
+}
+
+func (n exprOr) eval(fields map[string]interface{}) interface{} {
+	return truthy(n.x.eval(fields)) || truthy(n.y.eval(fields))
+}
+
+func (n exprMatch) eval(fields map[string]interface{}) interface{} {
+	x := n.x.eval(fields)
+	if x == nil {
+		return n.neg
+	}
+	return n.re.MatchString(valueString(x)) != n.neg
+}
+
+func (n exprCmp) eval(fields map[string]interface{}) interface{} {
+	x, y := n.x.eval(fields), n.y.eval(fields)
+	switch n.op {
+	case "==":
+		return valuesEqual(x, y)
+	case "!=":
+		return !valuesEqual(x, y)
+	case "in":
+		list, ok := y.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if valuesEqual(x, item) {
+				return true
+			}
+		}
+		return false
+	case "contains":
+		if list, ok := x.([]interface{}); ok {
+			for _, item := range list {
+				if valuesEqual(item, y) {
+					return true
+				}
+			}
+			return false
+		}
+		if x == nil || y == nil {
+			return false
+		}
+		return strings.Contains(valueString(x), valueString(y))
+	}
+	c, ok := compareValues(x, y)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// truthy converts value to condition
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) != 0
+	}
+	return true
+}
+
+// valueNumber converts value to number, numeric strings are allowed
+func valueNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil && !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+// valueString converts scalar value to string
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// valuesEqual compares values, numbers are compared by value
+func valuesEqual(x, y interface{}) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if a, ok := valueNumber(x); ok {
+		if b, ok := valueNumber(y); ok {
+			return a == b
+		}
+	}
+	if a, ok := x.(bool); ok {
+		b, ok := y.(bool)
+		return ok && a == b
+	}
+	if _, ok := y.(bool); ok {
+		return false
+	}
+	return valueString(x) == valueString(y)
+}
+
+// compareValues orders numbers or strings
+func compareValues(x, y interface{}) (int, bool) {
+	if a, ok := valueNumber(x); ok {
+		if b, ok := valueNumber(y); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	a, ok := x.(string)
+	if !ok {
+		return 0, false
+	}
+	b, ok := y.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(a, b), true
+}
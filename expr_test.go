@@ -0,0 +1,57 @@
+package webtail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpr(t *testing.T) {
+	fields := map[string]interface{}{
+		"level":      "error",
+		"latency_ms": "750", // logfmt values are strings
+		"path":       "/api/users",
+		"tags":       []interface{}{"db", "slow"},
+		"req":        map[string]interface{}{"status": float64(502)},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`level in ["error","fatal"] && latency_ms > 500 && !(path ~ "^/health")`, true},
+		{`level == "info" || latency_ms < 100`, false},
+		{`req.status >= 500 && req.status != 503`, true},
+		{`tags contains "slow" && path contains "users"`, true},
+		{`path !~ "^/api"`, false},
+		{`missing == null && !missing`, true},
+		{`missing > 1`, false},
+		{`level`, true},
+	}
+	for _, tt := range tests {
+		node, err := compileExpr(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, exprMatches(node, fields), tt.expr)
+	}
+}
+
+func TestExprError(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{`level == `, 10},
+		{`level ~ 5`, 9},
+		{`(a == 1`, 8},
+		{`a == "x`, 6},
+		{`a ~ "("`, 5},
+		{`a # b`, 3},
+		{`a b`, 3},
+	}
+	for _, tt := range tests {
+		_, err := compileExpr(tt.expr)
+		var exprErr *ExprError
+		require.ErrorAs(t, err, &exprErr, tt.expr)
+		assert.Equal(t, tt.pos, exprErr.Pos, tt.expr)
+	}
+}
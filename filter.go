@@ -0,0 +1,149 @@
+package webtail
+
+// This file holds server-side filter of attached channel lines
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+)
+
+// maxFilterContext is a max count of context lines
+const maxFilterContext = 100
+
+// ErrFilterContext returned when context is out of range
+var ErrFilterContext = errors.New("bad filter context")
+
+// TailFilter holds attach filter options
+type TailFilter struct {
+	Pattern    string `json:"pattern,omitempty"`
+	Regexp     bool   `json:"regexp,omitempty"` // Pattern is regexp, literal otherwise
+	IgnoreCase bool   `json:"icase,omitempty"`
+	Before     int    `json:"before,omitempty"`   // lines before match
+	After      int    `json:"after,omitempty"`    // lines after match
+	Expr       string `json:"expr,omitempty"`     // parsed fields condition, see expr.go
+	Template   int    `json:"template,omitempty"` // line template id, see templates.go
+}
+
+// FilterErrorMessage holds outgoing filter expression error
+type FilterErrorMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Data    string `json:"data"`
+	Pos     int    `json:"pos"`
+}
+
+// lineFilter holds filter state of subscription
+type lineFilter struct {
+	re       *regexp.Regexp
+	expr     exprNode // nil if not set
+	template int      // 0 if not set
+	before   uint64
+	after    int
+	// sequence number of the last sent line, 0 if none
+	last uint64
+	// lines to send after match
+	left int
+}
+
+// compilePattern compiles literal or regexp pattern
+func compilePattern(pattern string, isRegexp, ignoreCase bool) (*regexp.Regexp, error) {
+	if !isRegexp {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// newLineFilter checks filter options and creates filter, nil if filter is not set
+func newLineFilter(f *TailFilter) (*lineFilter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if f.Before < 0 || f.After < 0 || f.Before > maxFilterContext || f.After > maxFilterContext {
+		return nil, ErrFilterContext
+	}
+	re, err := compilePattern(f.Pattern, f.Regexp, f.IgnoreCase)
+	if err != nil {
+		return nil, err
+	}
+	rv := &lineFilter{re: re, before: uint64(f.Before), after: f.After, template: f.Template}
+	if f.Expr != "" {
+		if rv.expr, err = compileExpr(f.Expr); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// match checks if line matches pattern and expression
+func (f *lineFilter) match(msg *TailMessage) bool {
+	return (f.template == 0 || f.template == msg.Template) &&
+		f.re.MatchString(msg.Data) && (f.expr == nil || exprMatches(f.expr, msg.Fields))
+}
+
+// formatFilterError returns attach error message, expression errors carry position
+func formatFilterError(channel string, err error) []byte {
+	var exprErr *ExprError
+	if !errors.As(err, &exprErr) {
+		return formatTailMessage(channel, "attach", err.Error(), false)
+	}
+	data, _ := json.Marshal(FilterErrorMessage{Type: "error", Channel: channel, Data: exprErr.Error(), Pos: exprErr.Pos})
+	return data
+}
+
+// sendFiltered sends line with sequence number seq if it or its neighbours match filter
+// Preceding lines are taken from channel buffer, separator is sent between non-adjacent groups
+func (h *Hub) sendFiltered(client *Client, channel string, f *lineFilter, seq uint64, data []byte, msg *TailMessage) bool {
+	if !f.match(msg) {
+		if f.left == 0 {
+			return true
+		}
+		f.left--
+		f.last = seq
+		return h.send(client, data)
+	}
+	from := f.last + 1
+	if seq > f.before && seq-f.before > from {
+		from = seq - f.before
+	}
+	_, first := h.workers.TailerBufferSeq(channel)
+	from = max(from, first)
+	if f.last != 0 && from > f.last+1 {
+		sep, _ := json.Marshal(TailMessage{Type: "separator", Channel: channel})
+		if !h.send(client, sep) {
+			return false
+		}
+	}
+	exempt := h.workers.redactExempt(client)
+	for s := from; s < seq; s++ {
+		if !h.send(client, h.workers.bufferItem(channel, s, exempt)) {
+			return false
+		}
+	}
+	f.left = f.after
+	f.last = seq
+	return h.send(client, data)
+}
+
+// filtersReset clears filter state of channel subscribers
+// Called when worker is restarted because its line sequence numbers start again
+func (h *Hub) filtersReset(channel string) {
+	for _, sub := range h.subscribers[channel] {
+		if sub.filter != nil {
+			sub.filter.last, sub.filter.left = 0, 0
+		}
+	}
+}
+
+// TailerLine decodes buffered line of channel, fields are parsed again
+func (ts *TailService) TailerLine(channel string, data []byte) *TailMessage {
+	msg := &TailMessage{}
+	_ = json.Unmarshal(data, msg)
+	if parser := parsers[ts.workers[channel].Settings.Parser]; parser != nil {
+		msg.Fields = parser(msg.Data)
+	}
+	return msg
+}
@@ -0,0 +1,47 @@
+package webtail
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterContext(t *testing.T) {
+	cfg := &Config{Root: "testdata", Lines: 100}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+	ts.workers["a"] = &TailAttr{Lines: cfg.Lines}
+	h := NewHub(logr.Discard(), ts, &sync.WaitGroup{})
+	client := &Client{send: make(chan []byte, 100)}
+	f, err := newLineFilter(&TailFilter{Pattern: "ERR", Before: 1, After: 1})
+	require.NoError(t, err)
+
+	for _, line := range []string{"a", "b", "ERR 1", "c", "d", "e", "ERR 2", "ERR 3", "f", "g"} {
+		msg := TailMessage{Type: "log", Channel: "a", Data: line}
+		data, _ := json.Marshal(msg)
+		ts.TailerAppend("a", data, nil)
+		require.True(t, h.sendFiltered(client, "a", f, ts.TailerSeq("a"), data, &msg))
+	}
+	close(client.send)
+	got := []string{}
+	for data := range client.send {
+		msg := TailMessage{}
+		require.NoError(t, json.Unmarshal(data, &msg))
+		if msg.Type == "separator" {
+			msg.Data = "--"
+		}
+		got = append(got, msg.Data)
+	}
+	assert.Equal(t, []string{"b", "ERR 1", "c", "--", "e", "ERR 2", "ERR 3", "f"}, got)
+
+	h.subscribers["a"] = subscribers{client: &subscription{filter: f}}
+	h.filtersReset("a")
+	assert.Zero(t, f.last, "restarted worker numbers lines from 1")
+
+	_, err = newLineFilter(&TailFilter{Pattern: "x", After: maxFilterContext + 1})
+	assert.ErrorIs(t, err, ErrFilterContext)
+}
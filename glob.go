@@ -0,0 +1,50 @@
+package webtail
+
+// This file holds file name pattern matching
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob reports whether name (slash separated, relative to root) matches the shell pattern.
+// Pattern element "**" matches zero or more directories.
+// Pattern without slash is matched against the base name of file.
+func MatchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// ValidGlob checks pattern syntax
+func ValidGlob(pattern string) bool {
+	for _, p := range strings.Split(pattern, "/") {
+		if _, err := path.Match(p, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,120 @@
+package webtail
+
+// This file holds stale file health checks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health check statuses
+const (
+	HealthOK      = "ok"
+	HealthStale   = "stale"
+	HealthMissing = "missing"
+	HealthFail    = "fail"
+)
+
+// HealthRule holds max age of files matched by Glob
+type HealthRule struct {
+	Name     string        `yaml:"name"` // check name, Glob if empty
+	Root     string        `yaml:"root"` // root name, rule is applied to all roots if empty
+	Glob     string        `yaml:"glob"` // file path relative to root
+	MaxAge   time.Duration `yaml:"max_age"`
+	Optional bool          `yaml:"optional"` // check passes if no file matches
+}
+
+// HealthCheck holds result of health rule
+// The newest of matched files is checked
+type HealthCheck struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	MaxAge  int64     `json:"max_age"`        // seconds
+	Files   int       `json:"files"`          // matched files count
+	File    string    `json:"file,omitempty"` // the newest file
+	ModTime time.Time `json:"mtime,omitempty"`
+	Age     int64     `json:"age,omitempty"` // seconds
+}
+
+// HealthReport holds results of all health rules
+type HealthReport struct {
+	Status  string        `json:"status"`
+	Version uint64        `json:"version"` // index snapshot version
+	Time    time.Time     `json:"time"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// checkHealthRule checks health rule values
+func checkHealthRule(rule HealthRule) error {
+	if rule.Glob == "" || !ValidGlob(rule.Glob) {
+		return fmt.Errorf("bad glob %q", rule.Glob)
+	}
+	if rule.MaxAge <= 0 {
+		return fmt.Errorf("max_age required")
+	}
+	return nil
+}
+
+// healthReport checks index items against health rules
+func healthReport(rules []HealthRule, snap *IndexSnapshot, now time.Time) *HealthReport {
+	rv := &HealthReport{Status: HealthOK, Version: snap.Version, Time: now, Checks: make([]HealthCheck, len(rules))}
+	for i, rule := range rules {
+		check := HealthCheck{Name: rule.Name, MaxAge: int64(rule.MaxAge / time.Second)}
+		if check.Name == "" {
+			check.Name = rule.Glob
+		}
+		for _, item := range snap.Items {
+			rel := item.Name
+			if item.Root != "" {
+				rel = strings.TrimPrefix(rel, item.Root+"/")
+			}
+			if item.Deleted || (rule.Root != "" && rule.Root != item.Root) || !MatchGlob(rule.Glob, rel) {
+				continue
+			}
+			check.Files++
+			if check.File == "" || item.ModTime.After(check.ModTime) {
+				check.File, check.ModTime = item.Name, item.ModTime
+			}
+		}
+		switch {
+		case check.Files == 0 && rule.Optional:
+			check.Status = HealthOK
+		case check.Files == 0:
+			check.Status = HealthMissing
+		case now.Sub(check.ModTime) > rule.MaxAge:
+			check.Status = HealthStale
+		default:
+			check.Status = HealthOK
+		}
+		if check.Files > 0 {
+			check.Age = int64(now.Sub(check.ModTime) / time.Second)
+		}
+		if check.Status != HealthOK {
+			rv.Status = HealthFail
+		}
+		rv.Checks[i] = check
+	}
+	return rv
+}
+
+// ServeHealth serves file health checks as json
+// Status is 503 if any check fails
+func (wt *Service) ServeHealth(w http.ResponseWriter, _ *http.Request) {
+	ts := wt.hub.workers
+	snap := ts.snapshot.Load()
+	rules := ts.healthRules.Load()
+	if snap == nil || rules == nil {
+		http.Error(w, "index is not ready", http.StatusServiceUnavailable)
+		return
+	}
+	report := healthReport(*rules, snap, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if report.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
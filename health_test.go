@@ -0,0 +1,34 @@
+package webtail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReport(t *testing.T) {
+	now := time.Now()
+	snap := &IndexSnapshot{Version: 3, Items: []IndexItemEvent{
+		{Name: "jobs/backup-1.log", Root: "jobs", IndexItemAttr: IndexItemAttr{ModTime: now.Add(-48 * time.Hour)}},
+		{Name: "jobs/backup-2.log", Root: "jobs", IndexItemAttr: IndexItemAttr{ModTime: now.Add(-time.Hour)}},
+		{Name: "app/cron.log", Root: "app", IndexItemAttr: IndexItemAttr{ModTime: now.Add(-2 * time.Hour)}},
+	}}
+	rules := []HealthRule{
+		{Name: "backup", Root: "jobs", Glob: "backup-*.log", MaxAge: 26 * time.Hour},
+		{Glob: "cron.log", MaxAge: time.Hour},
+		{Glob: "missing.log", MaxAge: time.Hour},
+		{Glob: "optional.log", MaxAge: time.Hour, Optional: true},
+	}
+	report := healthReport(rules, snap, now)
+	assert.Equal(t, HealthFail, report.Status)
+	assert.Equal(t, HealthCheck{Name: "backup", Status: HealthOK, MaxAge: 93600, Files: 2,
+		File: "jobs/backup-2.log", ModTime: now.Add(-time.Hour), Age: 3600}, report.Checks[0])
+	statuses := []string{}
+	for _, check := range report.Checks {
+		statuses = append(statuses, check.Status)
+	}
+	assert.Equal(t, []string{HealthOK, HealthStale, HealthMissing, HealthOK}, statuses)
+
+	assert.Equal(t, HealthOK, healthReport(rules[:1], snap, now).Status)
+}
@@ -0,0 +1,322 @@
+package webtail
+
+// This file holds file history paging
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nxadm/tail/util"
+	"golang.org/x/text/encoding"
+)
+
+// historyChunk is the initial size of file window read backward
+const historyChunk = 64 * 1024
+
+// HistoryQuery holds history page request
+type HistoryQuery struct {
+	Before int64  `json:"before,omitempty"` // return lines ended before this offset, 0 - from the end of file
+	Limit  int    `json:"limit,omitempty"`  // 0 - Config.HistoryLines
+	File   string `json:"file,omitempty"`   // rotated file of channel which Before refers to, "" - channel itself
+}
+
+// HistoryLine holds file line with its end offset
+type HistoryLine struct {
+	Offset int64  `json:"offset"`
+	Data   string `json:"data"`
+	File   string `json:"file"` // file of rotation chain the line came from
+}
+
+// HistoryMessage holds outgoing history page
+type HistoryMessage struct {
+	Type    string        `json:"type"`
+	Channel string        `json:"channel"`
+	Data    []HistoryLine `json:"data"`
+	Next    int64         `json:"next,omitempty"` // Before value for the previous page, 0 - from the end of File
+	File    string        `json:"file,omitempty"` // File value for the previous page, empty if chain start reached
+}
+
+// chainFile holds file of rotation chain
+type chainFile struct {
+	name string
+	path string
+}
+
+// clientReply holds result of background job
+type clientReply struct {
+	client *Client
+	data   []byte
+	// called by hub after reply is sent
+	done func()
+}
+
+// historyPage holds lines collected for history page
+type historyPage struct {
+	lines []HistoryLine
+	// start offsets of lines
+	starts []int64
+	limit  int
+	// lines were dropped by limit
+	full bool
+}
+
+// add appends line keeping at most limit last lines
+// Parts of split line share end offset, so they are dropped together and are never split between pages
+func (p *historyPage) add(start, end int64, text string) {
+	p.lines = append(p.lines, HistoryLine{Offset: end, Data: text})
+	p.starts = append(p.starts, start)
+	for len(p.lines) > p.limit && p.starts[0] != start {
+		n := 1
+		for n < len(p.starts) && p.starts[n] == p.starts[0] {
+			n++
+		}
+		p.lines, p.starts = p.lines[n:], p.starts[n:]
+		p.full = true
+	}
+}
+
+// next returns Before value for the previous page
+func (p *historyPage) next() int64 {
+	if len(p.lines) == 0 || p.starts[0] == 0 {
+		return 0
+	}
+	return p.lines[0].Offset
+}
+
+// historyRequest starts history reading job
+func (h *Hub) historyRequest(channel string, query *HistoryQuery, client *Client) []byte {
+	if channel == "" || !h.workers.ChannelExists(channel) {
+		return formatTailMessage(channel, "history", MsgUnknownChannel, false)
+	}
+	if query == nil {
+		query = &HistoryQuery{}
+	}
+	set := h.workers.TailSettings(channel)
+	decoder, err := lineDecoder(set.Encoding)
+	if err != nil {
+		return formatTailMessage(channel, "history", err.Error(), false)
+	}
+	limit := h.workers.Config.HistoryLines
+	if query.Limit > 0 && (limit == 0 || query.Limit < limit) {
+		limit = query.Limit
+	}
+	if limit == 0 {
+		limit = set.Lines
+	}
+	var files []chainFile
+	for _, name := range h.workers.rotationChain(channel) {
+		if query.File != "" && name != query.File && files == nil {
+			continue
+		}
+		files = append(files, chainFile{name: name, path: h.workers.channelFile(name)})
+	}
+	if files == nil {
+		return formatTailMessage(channel, "history", MsgUnknownFile, false)
+	}
+	h.audit.Record(clientEvent(AuditHistory, client, channel))
+	before := query.Before
+	var redactors []*redactor
+	if !h.workers.redactExempt(client) {
+		redactors = newRedactors(set.Redact)
+	}
+	metrics := h.workers.metrics
+	limiter := h.workers.searchLimiter
+	go func() {
+		// history readers share workers budget with searches
+		select {
+		case limiter.workers <- struct{}{}:
+		case <-h.done:
+			return
+		}
+		lines, next, file, err := readChainHistory(files, before, limit, set.Split)
+		<-limiter.workers
+		var data []byte
+		if err != nil {
+			h.log.Error(err, "History read error", "channel", channel)
+			data = formatTailMessage(channel, "history", err.Error(), false)
+		} else {
+			lines = redactHistory(lines, decoder, redactors, set.Split, metrics)
+			data, _ = json.Marshal(HistoryMessage{Type: "history", Channel: channel, Data: lines, Next: next, File: file})
+		}
+		select {
+		case h.replies <- &clientReply{client: client, data: data}:
+		case <-h.done:
+		}
+	}()
+	return nil
+}
+
+// readChainHistory returns up to limit lines ended before given offset of the first file
+// History continues into the next files of rotation chain
+// Returns Before and File values for the previous page
+func readChainHistory(files []chainFile, before int64, limit, split int) ([]HistoryLine, int64, string, error) {
+	var rv []HistoryLine
+	for i, f := range files {
+		lines, next, err := readHistory(f.path, before, limit-len(rv), split)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		for j := range lines {
+			lines[j].File = f.name
+		}
+		rv = append(lines, rv...)
+		if next != 0 {
+			return rv, next, f.name, nil
+		}
+		if i == len(files)-1 {
+			break
+		}
+		if len(rv) >= limit {
+			return rv, 0, files[i+1].name, nil
+		}
+		before = 0
+	}
+	return rv, 0, "", nil
+}
+
+// readHistory returns up to limit lines ended before given offset
+// Offsets of compressed file are offsets in decompressed stream
+func readHistory(filename string, before int64, limit, split int) ([]HistoryLine, int64, error) {
+	compressed, err := isCompressed(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	var page *historyPage
+	if compressed {
+		page, err = scanHistory(filename, before, limit, split)
+	} else {
+		page, err = seekHistory(filename, before, limit, split)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return page.lines, page.next(), nil
+}
+
+// scanHistory reads file from start, used for compressed files
+func scanHistory(filename string, before int64, limit, split int) (*historyPage, error) {
+	r, _, err := openLog(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	page := &historyPage{limit: limit}
+	err = scanLines(r, 0, split, func(start, end int64, text string) bool {
+		if before > 0 && end >= before {
+			return false
+		}
+		page.add(start, end, text)
+		return true
+	})
+	return page, err
+}
+
+// seekHistory reads growing window from the end of plain file
+func seekHistory(filename string, before int64, limit, split int) (*historyPage, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := fi.Size()
+	if before > 0 && before < end {
+		end = before
+	}
+	for window := int64(historyChunk); ; window *= 2 {
+		start := max(end-window, 0)
+		if start > 0 {
+			// read previous byte to find out if the first line is complete
+			start--
+		}
+		page := &historyPage{limit: limit}
+		err = scanLines(io.NewSectionReader(fh, start, end-start), start, split, func(s, e int64, text string) bool {
+			if start > 0 && s == start {
+				// partial line
+				return true
+			}
+			if before > 0 && e >= before {
+				return false
+			}
+			page.add(s, e, text)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if start == 0 || page.full || len(page.lines) >= limit {
+			return page, nil
+		}
+	}
+}
+
+// scanLines calls fn for every line of r until fn returns false
+// Lines longer than split are partitioned, parts have the same end offset
+func scanLines(r io.Reader, base int64, split int, fn func(start, end int64, text string) bool) error {
+	br := bufio.NewReader(r)
+	pos := base
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			start := pos
+			pos += int64(len(line))
+			text := strings.TrimRight(line, "\n")
+			for _, part := range splitLine(text, split) {
+				if !fn(start, pos, part) {
+					return nil
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitLine partitions line into parts of split bytes, split = 0 disables it
+func splitLine(text string, split int) []string {
+	if split > 0 && len(text) > split {
+		return util.PartitionString(text, split)
+	}
+	return []string{text}
+}
+
+// redactHistory joins parts of split lines, decodes and redacts them and splits again
+// Lines are redacted before split, so secrets on part boundaries are found
+func redactHistory(lines []HistoryLine, decoder *encoding.Decoder, redactors []*redactor, split int, metrics *Metrics) []HistoryLine {
+	rv := make([]HistoryLine, 0, len(lines))
+	for i := 0; i < len(lines); {
+		text := lines[i].Data
+		j := i + 1
+		for ; j < len(lines) && lines[j].Offset == lines[i].Offset && lines[j].File == lines[i].File; j++ {
+			text += lines[j].Data
+		}
+		text, _ = redactLine(redactors, decodeLine(decoder, text), metrics)
+		for _, part := range splitLine(text, split) {
+			rv = append(rv, HistoryLine{Offset: lines[i].Offset, Data: part, File: lines[i].File})
+		}
+		i = j
+	}
+	return rv
+}
+
+// decodeLine converts line from file encoding
+func decodeLine(decoder *encoding.Decoder, text string) string {
+	if decoder == nil {
+		return text
+	}
+	rv, err := decoder.String(text)
+	if err != nil {
+		return text
+	}
+	return rv
+}
@@ -0,0 +1,126 @@
+package webtail
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHistory(t *testing.T) {
+	dir := t.TempDir()
+	var data strings.Builder
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&data, "line %05d\n", i) // 11 bytes per line
+	}
+	plain := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(plain, []byte(data.String()), 0o600))
+
+	packed := filepath.Join(dir, "app.log.1.gz")
+	f, err := os.Create(packed)
+	require.NoError(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write([]byte(data.String()))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	for _, file := range []string{plain, packed} {
+		lines, next, err := readHistory(file, 0, 2, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []HistoryLine{{Offset: 54989, Data: "line 04999"}, {Offset: 55000, Data: "line 05000"}}, lines)
+		assert.Equal(t, int64(54989), next)
+
+		lines, next, err = readHistory(file, next, 3, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "line 04996", lines[0].Data)
+		assert.Equal(t, "line 04998", lines[2].Data)
+		assert.Equal(t, int64(54956), next)
+
+		lines, next, err = readHistory(file, 22, 10, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []HistoryLine{{Offset: 11, Data: "line "}, {Offset: 11, Data: "00001"}}, lines)
+		assert.Equal(t, int64(0), next)
+	}
+
+	for _, file := range []string{plain, packed} {
+		// the 2nd line is split into 2 parts, page of 3 lines can't hold both lines
+		lines, next, err := readHistory(file, 33, 3, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []HistoryLine{{Offset: 22, Data: "line "}, {Offset: 22, Data: "00002"}}, lines, "split line is not cut by page")
+		assert.Equal(t, int64(22), next)
+	}
+
+	xz := filepath.Join(dir, "app.log.2.xz")
+	require.NoError(t, os.WriteFile(xz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 1}, 0o600))
+	_, _, err = readHistory(xz, 0, 10, 0)
+	assert.ErrorIs(t, err, ErrUnsupportedCompression)
+
+	src := newStaticTail(packed, TailSettings{Lines: 2})
+	var got []string
+	for line := range src.Lines() {
+		got = append(got, line.Text)
+	}
+	require.NoError(t, src.Err())
+	assert.Equal(t, []string{"line 04999", "line 05000"}, got)
+}
+
+func TestRotationChain(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ts := &TailService{
+		Config: &Config{Roots: []string{"main=" + dir}, Rotation: []string{`\.\d+(\.gz)?`}},
+		rules:  &Rules{},
+		roots:  []LogRoot{{Name: "main", Path: dir}},
+		index: IndexItemAttrStore{
+			"main/app.log":      {ModTime: now},
+			"main/app.log.1":    {ModTime: now.Add(-time.Hour)},
+			"main/app.log.2.gz": {ModTime: now.Add(-2 * time.Hour)},
+			"main/app.log.bak":  {ModTime: now},
+			"main/other.log":    {ModTime: now},
+		},
+	}
+	assert.Equal(t, []string{"main/app.log", "main/app.log.1", "main/app.log.2.gz"}, ts.rotationChain("main/app.log"))
+	assert.Equal(t, []string{"main/app.log.1", "main/app.log.2.gz"}, ts.rotationChain("main/app.log.1"))
+	assert.Equal(t, []string{"main/other.log"}, ts.rotationChain("main/other.log"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.log"), []byte("c1\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.log.1"), []byte("b1\nb2\n"), 0o600))
+	files := []chainFile{}
+	for _, name := range ts.rotationChain("main/app.log")[:2] {
+		files = append(files, chainFile{name: name, path: ts.channelFile(name)})
+	}
+	lines, next, file, err := readChainHistory(files, 0, 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []HistoryLine{{Offset: 6, Data: "b2", File: "main/app.log.1"}, {Offset: 3, Data: "c1", File: "main/app.log"}}, lines)
+	assert.Equal(t, int64(6), next)
+	assert.Equal(t, "main/app.log.1", file)
+
+	lines, next, file, err = readChainHistory(files[1:], next, 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []HistoryLine{{Offset: 3, Data: "b1", File: "main/app.log.1"}}, lines)
+	assert.Equal(t, int64(0), next)
+	assert.Equal(t, "", file)
+}
+
+func TestRedactHistory(t *testing.T) {
+	lines := []HistoryLine{
+		{Offset: 21, Data: "token=ab", File: "a.log"},
+		{Offset: 21, Data: "cdefghij", File: "a.log"},
+		{Offset: 21, Data: " end", File: "a.log"},
+		{Offset: 25, Data: "ok", File: "a.log"},
+	}
+	redactors := newRedactors([]RedactRule{{Pattern: `token=\w+`, Replace: "token=[REDACTED]"}})
+	assert.Equal(t, []HistoryLine{
+		{Offset: 21, Data: "token=[R", File: "a.log"},
+		{Offset: 21, Data: "EDACTED]", File: "a.log"},
+		{Offset: 21, Data: " end", File: "a.log"},
+		{Offset: 25, Data: "ok", File: "a.log"},
+	}, redactHistory(lines, nil, redactors, 8, NewMetrics()), "secret crossing split is redacted")
+}
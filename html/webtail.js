@@ -14,6 +14,10 @@ var WebTail = {
     title: '', // page title
     timer: null, // keepalive timer
     timeout: 5000, // ping & reconnect timeout
+    pageSize: 1000, // index items per page
+    next: null, // next index page cursor
+    first: null, // file offset of the first shown line
+    firstFile: '', // rotated file of the first shown line
     attached: null // attached channel
 };
 
@@ -65,6 +69,9 @@ function showFiles(file) {
     } else {
         p.find('[rel="link"]').text(f.name);
     }
+    if (f.renamed_from !== undefined) {
+        $('*[data-file="' + f.renamed_from + '"]').remove();
+    }
     var item = $('*[data-file="' + file.name + '"]');
 
     if (item.length === 0 && f.deleted) {
@@ -86,10 +93,35 @@ function showFiles(file) {
     }
     p.find('[rel="size"]')[0].innerHTML = sizeFormatted(f.size);
     p.find('[rel="mtime"]')[0].innerHTML = dateFormatted(f.mtime);
-    if (f.size > 0) p.find('[rel="link"]').attr("href", '#' + f.name);
+    if (f.size > 0 && !f.unreadable) p.find('[rel="link"]').attr("href", '#' + f.name);
     p.removeClass('hide');
 }
 
+// Show growth sparklines of files
+function showGrowth(data) {
+    const bars = '▁▂▃▄▅▆▇█';
+    $.each(data, function(name, values) {
+        var top = Math.max.apply(null, values);
+        var line = values.map(function(v) {
+            return v === 0 ? ' ' : bars[Math.min(bars.length - 1, Math.floor(v * bars.length / (top + 1)))];
+        }).join('');
+        $('*[data-file="' + name + '"]').find('[rel="spark"]').text(line).attr('title', sizeFormatted(values.reduce(function(a, b) { return a + b; }, 0)) + ' in 24h');
+    });
+}
+
+// Show button for next index page
+function showMore(next) {
+    WebTail.next = (next !== undefined) ? next : null;
+    if (WebTail.next === null) return;
+    var btn = $('<button>more files</button>');
+    btn.click(function() {
+        var m = JSON.stringify({ type: 'index_query', query: { limit: WebTail.pageSize, cursor: WebTail.next } });
+        window.console.debug("send: " + m);
+        WebTail.ws.send(m);
+    });
+    $('#log').empty().append(btn);
+}
+
 function titleReset() {
     if (WebTail.file !== '') {
         document.title = WebTail.file + WebTail.title + ' - WebTail';
@@ -115,6 +147,9 @@ function tail(file) {
 
 // Show files or tail page, reload on browser's back button
 function showPage() {
+    WebTail.next = null;
+    WebTail.first = null;
+    $('#older').addClass('hide');
     WebTail.unread = 0;
     WebTail.focused = true;
     var m;
@@ -131,7 +166,7 @@ function showPage() {
         $('#src').addClass('hide');
         $('#index').removeClass('hide');
         $('table.table thead tr:first').removeClass('hide'); // show header
-        m = JSON.stringify({ type: 'attach' })
+        m = JSON.stringify({ type: 'attach', query: { limit: WebTail.pageSize } })
         window.console.debug("send: " + m);
         WebTail.ws.send(m);
     } else {
@@ -192,15 +227,26 @@ function connect() {
 
 function processLine(l) {
     var m = JSON.parse(l, JSON.dateParser);
-    $("#log").text('');
+    if (WebTail.next === null) $("#log").text(''); // keep "more" button
 
     if (m.type === 'index') {
         showFiles(m.data);
+    } else if (m.type === 'index_list') {
+        m.data.forEach(showFiles);
+        showMore(m.next);
+        WebTail.ws.send(JSON.stringify({ type: 'series' }));
+    } else if (m.type === 'growth_list') {
+        showGrowth(m.data);
     } else if (m.type === 'detach') {
         // tail detached
         var mc = (m.channel !== undefined) ? m.channel : '';
         if (mc === WebTail.attached) {
             WebTail.attached = null;
+            if (m.data !== 'success') {
+                // detached by server, try to attach again
+                $('#log').text(m.data);
+                setTimeout(showPage, WebTail.timeout);
+            }
         }
     } else if (m.type === 'attach') {
         // tail attached
@@ -209,7 +255,21 @@ function processLine(l) {
         // TODO: stats requested by calling stats() in console
         window.console.log(JSON.stringify(m.data, null, 4))
     } else if (m.type === 'log') {
+        if (WebTail.first === null) showOlder(m.offset);
         processLog(m.data);
+        if (m.repeat !== undefined) showRepeat(m.repeat);
+    } else if (m.type === 'repeat') {
+        showRepeat(m.repeat);
+    } else if (m.type === 'separator') {
+        // gap between filtered line groups
+        processLog('--');
+    } else if (m.type === 'history') {
+        showHistory(m);
+    } else if (m.type === 'alert') {
+        window.console.warn("alert: %o", m.data);
+        $('#log').text('alert ' + m.data.rule + ': ' + m.data.line);
+    } else if (m.type === 'anomaly') {
+        window.console.warn("anomaly: %o", m.data);
     } else if (m.type === 'error') {
         window.console.warn("server error: %o", m);
         $('#log').text(m.data);
@@ -218,6 +278,26 @@ function processLine(l) {
     }
 }
 
+// Show button for file lines before offset
+function showOlder(offset, file) {
+    WebTail.first = (offset !== undefined) ? offset : 0;
+    WebTail.firstFile = (file !== undefined) ? file : '';
+    $('#older').toggleClass('hide', WebTail.first === 0 && WebTail.firstFile === '');
+}
+
+// Prepend history page to file lines
+function showHistory(m) {
+    var $area = $('#tail-data');
+    m.data.slice().reverse().forEach(function(line) {
+        $area.prepend("<br />");
+        var container = document.createElement("span");
+        container.title = line.file;
+        container.appendChild(document.createTextNode(line.data));
+        $area.prepend(container);
+    });
+    showOlder(m.next, m.file);
+}
+
 function processLog(data) {
     var $area = $('#tail-data');
     var str = (data !== undefined) ? data : '';
@@ -245,6 +325,17 @@ function processLog(data) {
 
 }
 
+// Show repeat count of the last line
+function showRepeat(n) {
+    var $br = $('#tail-data').children('br').last();
+    var $rep = $br.prev('span.repeat');
+    if ($rep.length === 0) {
+        $rep = $('<span class="repeat"></span>');
+        $br.before($rep);
+    }
+    $rep.text(' (repeated ' + n + ' times)');
+}
+
 // code from https://dev.opera.com/articles/fixing-the-scrolltop-bug/
 function bodyOrHtml() {
     if ('scrollingElement' in document) {
@@ -271,6 +362,12 @@ $(function() {
     WebTail.title = ' - ' + window.location.hostname;
     titleReset();
 
+    $('#older').click(function() {
+        var m = JSON.stringify({ type: 'history', channel: WebTail.file, history: { before: WebTail.first, file: WebTail.firstFile } });
+        window.console.debug("send: " + m);
+        WebTail.ws.send(m);
+    });
+
     $('#flag').click(function() {
         var obj = bodyOrHtml();
         obj.scrollTop = obj.scrollHeight;
@@ -1,8 +1,11 @@
 package webtail
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -16,13 +19,22 @@ const (
 	MsgNotSubscribed     = "not subscribed"
 	MsgWorkerError       = "worker create error"
 	MsgSubscribedAlready = "attached already"
+	MsgConfigReloaded    = "config reloaded"
+	MsgUnknownFile       = "unknown file"
+	MsgTemplatesDisabled = "templates are not enabled"
 	MsgNone              = ""
 )
 
 // InMessage holds incoming client request
 type InMessage struct {
-	Type    string `json:"type"`
-	Channel string `json:"channel,omitempty"`
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel,omitempty"`
+	Query     *IndexQuery     `json:"query,omitempty"`
+	History   *HistoryQuery   `json:"history,omitempty"`
+	Search    *SearchQuery    `json:"search,omitempty"`
+	Filter    *TailFilter     `json:"filter,omitempty"`
+	Templates *TemplatesQuery `json:"templates,omitempty"`
+	Aggregate *AggregateQuery `json:"aggregate,omitempty"`
 }
 
 // TailMessage holds outgoing file tail row
@@ -30,6 +42,17 @@ type TailMessage struct {
 	Type    string `json:"type"`
 	Channel string `json:"channel,omitempty"`
 	Data    string `json:"data,omitempty"`
+	Offset  int64  `json:"offset,omitempty"` // file offset after the line
+	// Template holds line template id if channel has template miner (see TailRule)
+	Template int `json:"template,omitempty"`
+	// Repeat holds count of collapsed equal lines if channel has dedup mode (see TailRule)
+	Repeat int `json:"repeat,omitempty"`
+
+	// raw holds line before redaction if it was changed
+	raw string
+
+	// Fields holds parsed line fields if channel has parser (see TailRule)
+	Fields map[string]interface{} `json:"-"`
 }
 
 // TraceMessage holds outgoing trace state
@@ -40,16 +63,19 @@ type TraceMessage struct {
 
 // StatsMessage holds outgoing app stats
 type StatsMessage struct {
-	Type string            `json:"type"`
-	Data map[string]uint64 `json:"data,omitempty"`
+	Type   string            `json:"type"`
+	Data   map[string]uint64 `json:"data,omitempty"`
+	Buffer *BufferStats      `json:"buffer,omitempty"`
+	Terms  *TermIndexStats   `json:"terms,omitempty"`
 }
 
 // IndexItemEvent holds messages from indexer
 type IndexItemEvent struct {
-	ModTime time.Time `json:"mtime"`
-	Size    int64     `json:"size"`
-	Name    string    `json:"name"`
-	Deleted bool      `json:"deleted,omitempty"`
+	IndexItemAttr
+	Name        string `json:"name"`
+	Root        string `json:"root,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	RenamedFrom string `json:"renamed_from,omitempty"`
 }
 
 // IndexMessage holds outgoing message item for file index
@@ -59,14 +85,35 @@ type IndexMessage struct {
 	Error string         `json:"error,omitempty"`
 }
 
+// IndexListMessage holds outgoing file index
+type IndexListMessage struct {
+	Type    string           `json:"type"`
+	Version uint64           `json:"version"`
+	Data    []IndexItemEvent `json:"data"`
+	Total   int              `json:"total,omitempty"`
+	Next    string           `json:"next,omitempty"`
+}
+
 // Message holds received message and sender
 type Message struct {
 	Client  *Client
 	Message []byte
 }
 
+// subscription holds client subscription options
+type subscription struct {
+	// index query, used for "" channel only
+	query *IndexQuery
+	// line filter, nil if all lines are sent
+	filter *lineFilter
+	// top-N tables are sent instead of lines if set
+	aggregate *AggregateQuery
+	// subscription time
+	since time.Time
+}
+
 // subscribers holds clients subscribed on channel
-type subscribers map[*Client]bool
+type subscribers map[*Client]*subscription
 
 // codebeat:disable[TOO_MANY_IVARS]
 
@@ -90,6 +137,24 @@ type Hub struct {
 	// Channel subscriber counts
 	stats map[string]uint64
 
+	// Directories expanded by clients in tree mode
+	trees map[*Client]map[string]bool
+
+	// Running client searches by id
+	searches map[*Client]map[string]context.CancelFunc
+
+	// Alert rules and pinned channels
+	alerts *alerter
+
+	// Next aggregate tables push time
+	aggregateAt time.Time
+
+	// Audit log, nil if disabled
+	audit *auditLog
+
+	// Config for http handlers, replaced with workers.Config on reload
+	config atomic.Pointer[Config]
+
 	// Inbound messages from the clients.
 	broadcast chan *Message
 
@@ -105,35 +170,67 @@ type Hub struct {
 	// Inbound messages from the channel indexer.
 	index chan *IndexItemEvent
 
+	// Config reload requests.
+	reload chan *reloadRequest
+
+	// Replies from background jobs.
+	replies chan *clientReply
+
+	// Search preparation requests.
+	search chan *searchRequest
+
 	// Quit channel
 	quit chan struct{}
+
+	// Closed when Run ends
+	done chan struct{}
 }
 
 // codebeat:enable[TOO_MANY_IVARS]
 
 // NewHub creates hub for client services
 func NewHub(logger logr.Logger, ts *TailService, wg *sync.WaitGroup) *Hub {
-	return &Hub{
+	h := &Hub{
 		log:         logger,
 		workers:     ts,
 		wg:          wg,
 		clients:     make(map[*Client]bool),
 		subscribers: make(map[string]subscribers),
 		stats:       make(map[string]uint64),
+		trees:       make(map[*Client]map[string]bool),
+		searches:    make(map[*Client]map[string]context.CancelFunc),
+		alerts:      &alerter{pinned: make(map[string]*pinnedChannel), skipped: make(map[string]bool), sender: newWebhookSender(logger, ts.metrics)},
 		broadcast:   make(chan *Message),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
 		receive:     make(chan *TailMessage),
 		index:       make(chan *IndexItemEvent),
+		reload:      make(chan *reloadRequest),
+		replies:     make(chan *clientReply),
+		search:      make(chan *searchRequest),
 		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
 	}
+	h.config.Store(ts.Config)
+	return h
 }
 
 // Run processes hub messages
 func (h *Hub) Run() {
+	defer close(h.done)
 	h.subscribers[""] = make(subscribers)
 	h.workers.IndexerRun(h.index, h.wg)
 	defer h.workers.WorkerStop("")
+	termsQuit := make(chan struct{})
+	defer close(termsQuit)
+	h.workers.TermIndexRun(termsQuit, h.wg)
+	h.workers.IndexSnapshot(0)
+	h.alerts = newAlerter(h.workers.rules.Alert, h.alerts)
+	go h.alerts.sender.run(h.done)
+	h.alertSync()
+	defer h.alertStop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	onAir := true
 	for {
 		select {
@@ -157,6 +254,21 @@ func (h *Hub) Run() {
 		case imessage := <-h.index:
 			// worker sends index update
 			h.fromIndexer(imessage)
+		case req := <-h.reload:
+			h.applyConfig(req)
+		case reply := <-h.replies:
+			// background job sends result
+			if h.clients[reply.client] {
+				h.send(reply.client, reply.data)
+			}
+			if reply.done != nil {
+				reply.done()
+			}
+		case req := <-h.search:
+			req.job, req.err = h.workers.prepareSearch(req.query)
+			close(req.done)
+		case <-ticker.C:
+			h.onTick()
 		case <-h.quit:
 			onAir = false
 			if len(h.clients) == 0 {
@@ -186,14 +298,65 @@ func (h *Hub) fromClient(msg *Message) {
 	h.log.Info("Received from Client", "message", in)
 	switch in.Type {
 	case "attach":
-		msgData, ok := h.subscribe(in.Channel, msg.Client)
+		sub := &subscription{query: in.Query}
+		if err = in.Query.Validate(); err != nil {
+			data = formatTailMessage(in.Channel, "attach", err.Error(), false)
+			break
+		}
+		if sub.filter, err = newLineFilter(in.Filter); err != nil {
+			data = formatFilterError(in.Channel, err)
+			break
+		}
+		msgData, ok := h.subscribe(in.Channel, msg.Client, sub)
 		data = formatTailMessage(in.Channel, "attach", msgData, ok)
+	case "aggregate":
+		// subscribe on top-N tables of channel fields
+		sub := &subscription{}
+		if sub.aggregate, err = h.workers.aggregateQuery(in.Channel, in.Aggregate); err != nil {
+			data = formatTailMessage(in.Channel, "aggregate", err.Error(), false)
+			break
+		}
+		msgData, ok := h.subscribe(in.Channel, msg.Client, sub)
+		data = formatTailMessage(in.Channel, "attach", msgData, ok)
+	case "series":
+		// send activity series of file or growth of all files
+		data = h.workers.Series(in.Channel, in.Query)
+	case "index_query":
+		// send index page without subscription
+		if in.Query == nil {
+			in.Query = &IndexQuery{}
+		}
+		if err = in.Query.Validate(); err != nil {
+			data = formatTailMessage("", "index_query", err.Error(), false)
+			break
+		}
+		data = h.indexPage(in.Query)
+	case "tree":
+		// send directory listing
+		data = h.treeExpand(in.Channel, msg.Client)
+	case "collapse":
+		data = h.treeCollapse(in.Channel, msg.Client)
+	case "history":
+		// send older lines of file
+		data = h.historyRequest(in.Channel, in.History, msg.Client)
+	case "templates":
+		// send top line templates of channel
+		if top := h.workers.TemplatesTop(in.Channel, in.Templates); top != nil {
+			data, _ = json.Marshal(top)
+		} else {
+			data = formatTailMessage(in.Channel, "templates", MsgTemplatesDisabled, false)
+		}
+	case "search":
+		// results are sent by search job
+		data = h.searchStart(in.Search, msg.Client)
+	case "search_cancel":
+		data = h.searchCancel(in.Search, msg.Client)
 	case "detach":
 		msgData, ok := h.unsubscribe(in.Channel, msg.Client)
 		data = formatTailMessage(in.Channel, "detach", msgData, ok)
 	case "stats":
 		// send index counters
-		data, _ = json.Marshal(StatsMessage{Type: "stats", Data: h.stats})
+		data, _ = json.Marshal(StatsMessage{Type: "stats", Data: h.stats, Buffer: h.workers.BufferStats(), Terms: h.workers.TermStats()})
 	case "trace":
 		// on/off tracing
 		h.workers.SetTrace(in.Channel)
@@ -209,13 +372,53 @@ func (h *Hub) fromTailer(msg *TailMessage) {
 	if h.workers.TraceEnabled() {
 		h.log.Info("Trace from tailer", "channel", msg.Channel, "data", msg.Data, "type", msg.Type)
 	}
-	data, _ := json.Marshal(msg)
-	if msg.Type == "log" && !h.workers.TailerAppend(msg.Channel, data) {
-		h.log.Info("Incomplete line skipped")
+	// lines read on worker start are not counted as arriving now
+	live := h.workers.TailerLive(msg)
+	msg.Template = h.workers.TemplateAdd(msg, live)
+	if live {
+		h.workers.AggregateAdd(msg)
+		h.workers.SeriesAdd(msg)
+	}
+	if rep, ok := h.workers.TailerRepeat(msg); ok {
+		h.sendRepeat(msg.Channel, rep)
+		h.alertLine(msg)
 		return
 	}
+	data, _ := json.Marshal(msg)
+	var raw []byte
+	if msg.raw != "" && len(h.workers.rules.RedactExempt) > 0 {
+		orig := *msg
+		orig.Data = msg.raw
+		raw, _ = json.Marshal(orig)
+	}
+	if msg.Type == "log" {
+		h.workers.TailerAppend(msg.Channel, data, raw)
+	}
 	clients := h.subscribers[msg.Channel]
-	for client := range clients {
+	for client, sub := range clients {
+		if sub.aggregate != nil {
+			continue
+		}
+		out := data
+		if raw != nil && h.workers.redactExempt(client) {
+			out = raw
+		}
+		if sub.filter != nil && msg.Type == "log" {
+			h.sendFiltered(client, msg.Channel, sub.filter, h.workers.TailerSeq(msg.Channel), out, msg)
+			continue
+		}
+		h.send(client, out)
+	}
+	h.alertLine(msg)
+}
+
+// sendRepeat sends repeat count of the last line to subscribers who got this line
+func (h *Hub) sendRepeat(channel string, data []byte) {
+	seq := h.workers.TailerSeq(channel)
+	for client, sub := range h.subscribers[channel] {
+		if sub.aggregate != nil || (sub.filter != nil && sub.filter.last != seq) {
+			continue
+		}
 		h.send(client, data)
 	}
 }
@@ -225,75 +428,140 @@ func (h *Hub) fromIndexer(msg *IndexItemEvent) {
 	if h.workers.TraceEnabled() {
 		h.log.Info("Trace from indexer", "message", msg)
 	}
-	data, _ := json.Marshal(IndexMessage{Type: "index", Data: *msg})
+	if !h.workers.IndexAllowed(msg.Name) {
+		return
+	}
 	h.workers.IndexUpdate(msg)
+	h.workers.TermUpdate(msg)
+	h.alertPin(msg.Name)
+	if msg.RenamedFrom != "" {
+		h.alertPin(msg.RenamedFrom)
+	}
+	data, _ := json.Marshal(IndexMessage{Type: "index", Data: *msg})
+	h.workers.IndexSnapshot(h.cacheAge())
+	h.treeNotify(msg.Name)
+	if msg.RenamedFrom != "" {
+		h.treeNotify(msg.RenamedFrom)
+	}
 	clients := h.subscribers[""]
-	for client := range clients {
-		h.send(client, data)
+	for client, sub := range clients {
+		if sub.query.Match(msg.Name) || (msg.RenamedFrom != "" && sub.query.Match(msg.RenamedFrom)) {
+			h.send(client, data)
+		}
 	}
 }
 
-func (h *Hub) subscribe(channel string, client *Client) (string, bool) {
+func (h *Hub) subscribe(channel string, client *Client, sub *subscription) (string, bool) {
 	var err error
 	if !h.workers.ChannelExists(channel) {
 		return MsgUnknownChannel, false
 	}
 	if !h.workers.WorkerExists(channel) {
-		readyChan := make(chan struct{})
 		// no producer => create
-		err = h.workers.TailerRun(channel, h.receive, readyChan, h.wg)
+		err = h.tailerStart(channel)
+		if errors.Is(err, ErrUnsupportedCompression) || errors.Is(err, ErrUnsupportedEncoding) {
+			return err.Error(), false
+		}
 		if err != nil {
 			h.log.Error(err, "Worker create error")
 			return MsgWorkerError, false
 		}
-		h.subscribers[channel] = make(subscribers)
-		<-readyChan
 	} else if _, ok := h.subscribers[channel][client]; ok {
 		return MsgSubscribedAlready, false
 	}
 	// Confirm attach
 	// not via data because have to be first in response
 	if h.send(client, formatTailMessage(channel, "attach", MsgSubscribed, true)) {
-		if h.sendReply(channel, client) {
+		if h.sendReply(channel, client, sub) {
 			// subscribe client
-			h.subscribers[channel][client] = true
+			sub.since = time.Now()
+			h.subscribers[channel][client] = sub
 			h.stats[channel]++
+			if channel != "" {
+				h.audit.Record(clientEvent(AuditAttach, client, channel))
+			}
 		}
 	}
 	return MsgNone, true
 }
 
-func (h *Hub) sendReply(ch string, cl *Client) bool {
+// tailerStart runs tail worker of channel and waits for its start
+func (h *Hub) tailerStart(channel string) error {
+	readyChan := make(chan struct{})
+	if err := h.workers.TailerRun(channel, h.receive, readyChan, h.wg); err != nil {
+		return err
+	}
+	h.subscribers[channel] = make(subscribers)
+	<-readyChan
+	return nil
+}
+
+func (h *Hub) sendReply(ch string, cl *Client, sub *subscription) bool {
+	if sub.aggregate != nil {
+		return h.send(cl, h.workers.AggregateTable(ch, sub.aggregate))
+	}
 	if ch != "" {
 		// send actual buffer
-		for _, item := range h.workers.TailerBuffer(ch) {
-			if !h.send(cl, item) {
+		buf, first := h.workers.TailerBufferSeq(ch)
+		exempt := h.workers.redactExempt(cl)
+		for i := range buf {
+			item := h.workers.bufferItem(ch, first+uint64(i), exempt)
+			ok := true
+			if sub.filter != nil {
+				ok = h.sendFiltered(cl, ch, sub.filter, first+uint64(i), item, h.workers.TailerLine(ch, item))
+			} else {
+				ok = h.send(cl, item)
+			}
+			if !ok {
 				return false
 			}
 		}
 		return true
 	}
 	// send channel index
-	for _, v := range h.workers.IndexKeys() {
-		file := h.workers.IndexItem(v)
-		idx := &IndexMessage{
-			Type: "index",
-			Data: IndexItemEvent{
-				Name:    v,
-				ModTime: file.ModTime,
-				Size:    file.Size,
-			},
-		}
-		data, _ := json.Marshal(idx)
-		if !h.send(cl, data) {
-			return false
-		}
+	if sub.query != nil {
+		return h.send(cl, h.indexPage(sub.query))
+	}
+	snap := h.workers.IndexSnapshot(0)
+	data, _ := json.Marshal(IndexListMessage{Type: "index_list", Version: snap.Version, Data: snap.Items})
+	return h.send(cl, data)
+}
+
+// indexPage returns index page message for query
+func (h *Hub) indexPage(query *IndexQuery) []byte {
+	snap := h.workers.IndexSnapshot(0)
+	page := query.Run(snap.Items)
+	data, _ := json.Marshal(IndexListMessage{
+		Type:    "index_list",
+		Version: snap.Version,
+		Data:    page.Items,
+		Total:   page.Total,
+		Next:    page.Next,
+	})
+	return data
+}
+
+// onTick runs periodic jobs
+func (h *Hub) onTick() {
+	// rebuild snapshot skipped by cache
+	now := time.Now()
+	h.workers.RateDecay(now)
+	h.workers.IndexSnapshot(h.cacheAge())
+	h.alertTick(now)
+	h.aggregateTick(now)
+	for _, ev := range h.workers.AnomalyTick(now) {
+		h.anomalyNotify(ev)
 	}
-	return true
+}
+
+// cacheAge returns max age of index snapshot
+func (h *Hub) cacheAge() time.Duration {
+	return time.Duration(h.workers.Config.ListCache) * time.Second
 }
 
 func (h *Hub) send(client *Client, data []byte) bool {
-	h.log.Info("Send reply", "message", string(data))
+	// message is not logged, it may hold unredacted line
+	h.log.V(1).Info("Send reply", "bytes", len(data))
 	select {
 	case client.send <- data:
 	default:
@@ -311,6 +579,8 @@ func (h *Hub) unsubscribeClient(client *Client, needsClose bool) {
 			h.unsubscribe(k, client)
 		}
 	}
+	delete(h.trees, client)
+	h.searchStop(client)
 	if needsClose {
 		close(client.send)
 	}
@@ -322,12 +592,18 @@ func (h *Hub) unsubscribe(channel string, client *Client) (string, bool) {
 	if !ok {
 		return MsgUnknownChannel, false
 	}
-	if _, ok = subscribers[client]; !ok {
+	sub, ok := subscribers[client]
+	if !ok {
 		return MsgNotSubscribed, false
 	}
+	if channel != "" {
+		event := clientEvent(AuditDetach, client, channel)
+		event.Duration = time.Since(sub.since).Seconds()
+		h.audit.Record(event)
+	}
 	delete(h.subscribers[channel], client)
 	h.stats[channel]--
-	if channel != "" && h.stats[channel] == 0 {
+	if _, pinned := h.alerts.pinned[channel]; channel != "" && h.stats[channel] == 0 && !pinned {
 		// tailer has no subscribers => stop it
 		h.workers.WorkerStop(channel)
 	}
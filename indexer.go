@@ -3,6 +3,7 @@ package webtail
 // This file holds directory tree indexer methods
 
 import (
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -16,36 +17,99 @@ import (
 
 // IndexItemAttr holds File (index item) Attrs
 type IndexItemAttr struct {
-	ModTime time.Time `json:"mtime"`
-	Size    int64     `json:"size"`
+	ModTime    time.Time `json:"mtime"`
+	Size       int64     `json:"size"`
+	Unreadable bool      `json:"unreadable,omitempty"`
+	Owner      string    `json:"owner,omitempty"`
+	Mode       string    `json:"mode,omitempty"`
+	Compressed string    `json:"compressed,omitempty"` // compression format
+	Binary     bool      `json:"binary,omitempty"`
+	Encoding   string    `json:"encoding,omitempty"`
+	Parser     string    `json:"parser,omitempty"`
+	Rate       float64   `json:"rate,omitempty"` // growth rate, bytes per minute
+
+	// time of stat
+	seen time.Time
+	// size growth series, nil if file did not grow
+	growth *rollingCounts
+	// tailed lines and bytes series, nil if file was not tailed
+	// It is kept here to survive worker restarts
+	series *lineSeries
 }
 
 // IndexItemAttrStore holds all index items
 type IndexItemAttrStore map[string]*IndexItemAttr
 
+// renameWait is a time to wait for create event after rename
+const renameWait = 100 * time.Millisecond
+
 type indexWorker struct {
 	out  chan *IndexItemEvent
 	quit chan struct{}
 	log  logr.Logger
-	root string
+	root LogRoot
+	// rescan period, inotify is used if zero
+	poll time.Duration
+	// max files per second while rescan, 0 - no limit
+	rate int
+	// write events coalescing window, 0 - no coalescing
+	debounce time.Duration
+	// items of loaded index, poller sends changes from them
+	known IndexItemAttrStore
+	// files with events, used to confirm renames and skip content detection
+	stats map[string]fileStat
+}
+
+// fileStat holds stat and attrs of file
+type fileStat struct {
+	info os.FileInfo
+	attr *IndexItemAttr
 }
 
-// IndexerRun runs indexer
+// IndexerRun runs indexer for every root
+// All indexers are stopped by WorkerStop("")
 func (ts *TailService) IndexerRun(out chan *IndexItemEvent, wg *sync.WaitGroup) {
 	quit := make(chan struct{})
 	ts.workers[""] = &TailAttr{Quit: quit}
-	readyChan := make(chan struct{})
-	go indexWorker{
-		out:  out,
-		quit: quit,
-		log:  ts.log,
-		root: ts.Config.Root,
-	}.run(readyChan, wg)
-	<-readyChan
-	err := loadIndex(ts.index, ts.Config.Root, time.Now())
-	if err != nil {
-		ts.log.Error(err, "Path walk")
+	for _, root := range ts.roots {
+		// buffered because worker signals on exit too
+		readyChan := make(chan struct{}, 1)
+		iw := indexWorker{
+			out:  out,
+			quit: quit,
+			log:  ts.log.WithValues("root", root.Name),
+			root: root,
+			poll: time.Duration(ts.Config.IndexPoll) * time.Second,
+			rate: ts.Config.IndexPollRate,
+
+			debounce: time.Duration(ts.Config.IndexDebounce) * time.Millisecond,
+		}
+		if iw.poll > 0 {
+			// poller compares rescans with loaded index, so it is loaded before start
+			if err := loadIndex(ts.index, root, time.Now()); err != nil {
+				ts.log.Error(err, "Path walk", "root", root.Name)
+			}
+			iw.known = pollSeed(ts.index, root)
+			go iw.run(readyChan, wg)
+			<-readyChan
+			continue
+		}
+		go iw.run(readyChan, wg)
+		<-readyChan
+		err := loadIndex(ts.index, root, time.Now())
+		if err != nil {
+			ts.log.Error(err, "Path walk", "root", root.Name)
+		}
 	}
+	for k, v := range ts.index {
+		if !ts.IndexAllowed(k) {
+			delete(ts.index, k)
+			continue
+		}
+		ts.applyRules(k, v)
+	}
+	ts.indexVersion++
+	ts.treeRebuild()
 	ts.log.V(1).Info("Indexer started")
 }
 
@@ -70,16 +134,38 @@ func (ts *TailService) IndexItem(key string) *IndexItemAttr {
 
 // IndexUpdate updates TailService index item
 func (ts *TailService) IndexUpdate(msg *IndexItemEvent) {
+	ts.indexVersion++
+	renamed, wasRenamed := ts.index[msg.RenamedFrom]
+	if wasRenamed {
+		delete(ts.index, msg.RenamedFrom)
+		ts.treeDelete(msg.RenamedFrom)
+	}
 	if !msg.Deleted {
-		ts.index[msg.Name] = &IndexItemAttr{ModTime: msg.ModTime, Size: msg.Size}
+		attr := msg.IndexItemAttr
+		prev, ok := ts.index[msg.Name]
+		if !ok && wasRenamed {
+			// renamed file keeps its history
+			prev, ok = renamed, true
+		}
+		if ok {
+			attr.Rate = growthRate(prev, &attr)
+			attr.series = prev.series
+			addGrowth(prev, &attr, time.Now())
+		}
+		ts.applyRules(msg.Name, &attr)
+		msg.IndexItemAttr = attr
+		ts.index[msg.Name] = &attr
+		ts.treeAdd(msg.Name)
 		return
 	}
 	if _, ok := ts.index[msg.Name]; ok {
 		ts.log.Info("Deleting path from index", "path", msg.Name)
 		items := ts.index
 		for k := range items {
-			if strings.HasPrefix(k, msg.Name) {
+			// rotated siblings like name.1 are kept
+			if k == msg.Name || strings.HasPrefix(k, msg.Name+"/") {
 				delete(ts.index, k)
+				ts.treeDelete(k)
 			}
 		}
 	}
@@ -93,6 +179,10 @@ func (iw indexWorker) run(readyChan chan struct{}, wg *sync.WaitGroup) {
 		wg.Done()
 		readyChan <- struct{}{}
 	}()
+	if iw.poll > 0 {
+		iw.runPoll(readyChan)
+		return
+	}
 	//	logger := func(args ...interface{}) {} // Is it called ever?
 	watcher, err := fsnotify.NewWatcher() //dirwatch.Notify(notify), dirwatch.Logger(logger))
 	if err != nil {
@@ -101,21 +191,60 @@ func (iw indexWorker) run(readyChan chan struct{}, wg *sync.WaitGroup) {
 	}
 
 	defer watcher.Close()
-	watcher.Add(iw.root)
+	watcher.Add(iw.root.Path)
+	iw.stats = make(map[string]fileStat)
 	readyChan <- struct{}{}
+	var (
+		// files with coalesced write events
+		written = make(map[string]bool)
+		flush   <-chan time.Time
+		// file renamed, waiting for create event with new name
+		renamed     string
+		renameTimer <-chan time.Time
+	)
 	for {
 		select {
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
-			// log.Println("event:", event)
-			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) {
-				iw.log.Info("Handling file event", "event", event)
-				if err := sendUpdate(iw.out, iw.root, event.Name); err != nil {
-					iw.log.Error(err, "Cannot get stat for file", "filepath", event.Name)
+			iw.log.V(1).Info("Handling file event", "event", event)
+			switch {
+			case event.Has(fsnotify.Rename):
+				if renamed != "" {
+					iw.sendUpdate(renamed, "")
+				}
+				delete(written, event.Name)
+				renamed, renameTimer = event.Name, time.After(renameWait)
+			case event.Has(fsnotify.Create) && renamed != "":
+				if iw.renameTarget(renamed, event.Name) {
+					iw.sendUpdate(event.Name, renamed)
+				} else {
+					// unrelated file, renamed one was moved out of root
+					iw.sendUpdate(renamed, "")
+					iw.sendUpdate(event.Name, "")
 				}
+				renamed, renameTimer = "", nil
+			case event.Has(fsnotify.Write) && iw.debounce > 0:
+				written[event.Name] = true
+				if flush == nil {
+					flush = time.After(iw.debounce)
+				}
+			case event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) ||
+				event.Has(fsnotify.Chmod):
+				delete(written, event.Name)
+				iw.sendUpdate(event.Name, "")
+			}
+		case <-flush:
+			for name := range written {
+				iw.sendUpdate(name, "")
 			}
+			clear(written)
+			flush = nil
+		case <-renameTimer:
+			// file was moved out of root
+			iw.sendUpdate(renamed, "")
+			renamed, renameTimer = "", nil
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
@@ -130,34 +259,142 @@ func (iw indexWorker) run(readyChan chan struct{}, wg *sync.WaitGroup) {
 }
 
 // sendUpdate sends index update to out channel
-func sendUpdate(out chan *IndexItemEvent, root, filePath string) error {
-	dir := strings.TrimSuffix(root, "/")
-	p := strings.TrimPrefix(filePath, dir+"/")
-
+// If file was renamed, oldPath holds its previous name
+func (iw indexWorker) sendUpdate(filePath, oldPath string) {
+	event := &IndexItemEvent{Name: iw.itemName(filePath), Root: iw.root.Name}
+	if oldPath != "" {
+		event.RenamedFrom = iw.itemName(oldPath)
+	}
+	prev, ok := iw.stats[filePath]
+	if oldPath != "" {
+		prev, ok = iw.stats[oldPath]
+		delete(iw.stats, oldPath)
+	}
 	f, err := os.Stat(filePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			out <- &IndexItemEvent{Name: p, Deleted: true}
-		} else {
-			return err
+		if !os.IsNotExist(err) {
+			iw.log.Error(err, "Cannot get stat for file", "filepath", filePath)
+			return
 		}
-	} else if !f.IsDir() {
-		out <- &IndexItemEvent{Name: p, ModTime: f.ModTime(), Size: f.Size()}
+		event.Deleted = true
+		delete(iw.stats, filePath)
+	} else if f.IsDir() {
+		return
+	} else {
+		var known *IndexItemAttr
+		if ok && os.SameFile(prev.info, f) {
+			known = prev.attr
+		}
+		attr := fileAttr(filePath, f, known)
+		event.IndexItemAttr = *attr
+		if iw.stats != nil {
+			iw.stats[filePath] = fileStat{info: f, attr: attr}
+		}
+	}
+	iw.send(event)
+}
+
+// renameTarget checks if created file is the renamed one
+// File is compared with its stat known before rename, otherwise both names must be in the same directory
+func (iw indexWorker) renameTarget(oldPath, newPath string) bool {
+	if old, ok := iw.stats[oldPath]; ok {
+		f, err := os.Stat(newPath)
+		return err == nil && os.SameFile(old.info, f)
+	}
+	return filepath.Dir(oldPath) == filepath.Dir(newPath)
+}
+
+// itemName returns index item name for file path
+func (iw indexWorker) itemName(filePath string) string {
+	dir := strings.TrimSuffix(iw.root.Path, "/")
+	return iw.root.prefix() + filepath.ToSlash(strings.TrimPrefix(filePath, dir+"/"))
+}
+
+// send sends event to hub unless worker is stopping
+func (iw indexWorker) send(event *IndexItemEvent) {
+	select {
+	case iw.out <- event:
+	case <-iw.quit:
 	}
-	return nil
 }
 
 // loadIndex loads index items for the first time
-func loadIndex(index IndexItemAttrStore, root string, lastmod time.Time) error {
-	dir := strings.TrimSuffix(root, "/")
-	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+func loadIndex(index IndexItemAttrStore, root LogRoot, lastmod time.Time) error {
+	dir := strings.TrimSuffix(root.Path, "/")
+	err := filepath.Walk(root.Path, func(path string, f os.FileInfo, err error) error {
 		if !f.IsDir() {
 			if f.ModTime().Before(lastmod) {
-				p := strings.TrimPrefix(path, dir+"/")
-				index[p] = &IndexItemAttr{ModTime: f.ModTime(), Size: f.Size()}
+				p := root.prefix() + filepath.ToSlash(strings.TrimPrefix(path, dir+"/"))
+				index[p] = fileAttr(path, f, nil)
 			}
 		}
 		return nil
 	})
 	return err
 }
+
+// fileAttr returns index attrs of file
+// File content is detected again only if known attrs are nil, file was empty, truncated or its mode changed
+func fileAttr(filePath string, f os.FileInfo, known *IndexItemAttr) *IndexItemAttr {
+	rv := &IndexItemAttr{
+		ModTime: f.ModTime(),
+		Size:    f.Size(),
+		Owner:   fileOwner(f),
+		Mode:    f.Mode().String(),
+		seen:    time.Now(),
+	}
+	if known != nil && known.Size > 0 && !known.Unreadable && rv.Size >= known.Size && rv.Mode == known.Mode {
+		rv.Compressed, rv.Binary, rv.Encoding, rv.Parser = known.Compressed, known.Binary, known.Encoding, known.Parser
+		return rv
+	}
+	fh, err := os.Open(filePath)
+	if err != nil {
+		rv.Unreadable = true
+		return rv
+	}
+	defer fh.Close()
+	if rv.Size > 0 {
+		sniffFile(fh, rv)
+	}
+	return rv
+}
+
+// rateIdle is a time without index events after which file growth rate is reset
+const rateIdle = 5 * time.Minute
+
+// RateDecay resets growth rate of files which were not changed within rateIdle
+func (ts *TailService) RateDecay(now time.Time) {
+	for _, attr := range ts.index {
+		if attr.Rate > 0 && now.Sub(attr.seen) > rateIdle {
+			attr.Rate = 0
+			ts.indexVersion++
+		}
+	}
+}
+
+// growthRate returns file growth rate (bytes per minute) smoothed with previous value
+func growthRate(prev, cur *IndexItemAttr) float64 {
+	if prev.seen.IsZero() || cur.seen.IsZero() || cur.Size < prev.Size {
+		return 0
+	}
+	minutes := cur.seen.Sub(prev.seen).Minutes()
+	if minutes <= 0 {
+		return prev.Rate
+	}
+	rate := float64(cur.Size-prev.Size) / minutes
+	if prev.Rate > 0 {
+		rate = (prev.Rate + rate) / 2
+	}
+	return math.Round(rate*10) / 10
+}
+
+// applyRules sets attrs defined by tail rules
+func (ts *TailService) applyRules(name string, attr *IndexItemAttr) {
+	set := ts.TailSettings(name)
+	if set.Parser != "" {
+		attr.Parser = set.Parser
+	}
+	if set.Encoding != "" {
+		attr.Encoding = set.Encoding
+	}
+}
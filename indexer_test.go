@@ -0,0 +1,82 @@
+package webtail
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexerEvents(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(file, []byte{}, 0o600))
+
+	out := make(chan *IndexItemEvent)
+	quit := make(chan struct{})
+	readyChan := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	go indexWorker{
+		out:      out,
+		quit:     quit,
+		log:      logr.Discard(),
+		root:     LogRoot{Path: dir},
+		debounce: 50 * time.Millisecond,
+	}.run(readyChan, &wg)
+	<-readyChan
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = f.WriteString("row\n")
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	event := <-out
+	assert.Equal(t, "app.log", event.Name)
+	assert.Equal(t, int64(40), event.Size, "Writes coalesced")
+
+	require.NoError(t, os.Rename(file, filepath.Join(dir, "app.log.1")))
+	event = <-out
+	assert.Equal(t, "app.log.1", event.Name)
+	assert.Equal(t, "app.log", event.RenamedFrom)
+	assert.Equal(t, int64(40), event.Size)
+
+	close(quit)
+	wg.Wait()
+}
+
+func TestRenameTarget(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("row\n"), 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+	a, err := os.Stat(filepath.Join(dir, "a.log"))
+	require.NoError(t, err)
+	iw := indexWorker{stats: map[string]fileStat{filepath.Join(dir, "old.log"): {info: a}}}
+	require.NoError(t, os.Rename(filepath.Join(dir, "a.log"), filepath.Join(dir, "a.log.1")))
+	assert.True(t, iw.renameTarget(filepath.Join(dir, "old.log"), filepath.Join(dir, "a.log.1")))
+	assert.False(t, iw.renameTarget(filepath.Join(dir, "old.log"), filepath.Join(dir, "b.log")), "other file")
+	assert.True(t, iw.renameTarget(filepath.Join(dir, "x.log"), filepath.Join(dir, "b.log")), "unknown file in the same dir")
+	assert.False(t, iw.renameTarget(filepath.Join(dir, "x.log"), filepath.Join(dir, "sub", "b.log")))
+}
+
+func TestIndexDelete(t *testing.T) {
+	ts := &TailService{Config: &Config{}, rules: &Rules{}, index: IndexItemAttrStore{}}
+	ts.treeRebuild()
+	for _, name := range []string{"app.log", "app.log.1", "app.log-20260101", "dir", "dir/a.log"} {
+		ts.IndexUpdate(&IndexItemEvent{Name: name, IndexItemAttr: IndexItemAttr{Size: 1}})
+	}
+	// file moved out of root
+	ts.IndexUpdate(&IndexItemEvent{Name: "app.log", Deleted: true})
+	ts.IndexUpdate(&IndexItemEvent{Name: "dir", Deleted: true})
+	assert.ElementsMatch(t, []string{"app.log.1", "app.log-20260101"}, slices.Collect(maps.Keys(ts.index)), "rotated siblings are kept")
+}
@@ -0,0 +1,119 @@
+package webtail
+
+// This file holds service metrics registry
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric types
+const (
+	MetricGauge   = "gauge"
+	MetricCounter = "counter"
+)
+
+// metricInfo holds metric description
+type metricInfo struct {
+	kind string
+	help string
+}
+
+// Metrics holds service counters and gauges
+// It is safe for concurrent use and serves values in prometheus text format
+type Metrics struct {
+	mu     sync.RWMutex
+	info   map[string]metricInfo
+	values map[string]map[string]float64
+}
+
+// NewMetrics creates metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		info:   make(map[string]metricInfo),
+		values: make(map[string]map[string]float64),
+	}
+}
+
+// Describe registers metric type and help text
+func (m *Metrics) Describe(name, kind, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.info[name] = metricInfo{kind: kind, help: help}
+	if _, ok := m.values[name]; !ok {
+		m.values[name] = make(map[string]float64)
+	}
+}
+
+// Set sets metric value, labels are given as name, value pairs
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name)[formatLabels(labels)] = value
+}
+
+// Add adds delta to metric value
+func (m *Metrics) Add(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name)[formatLabels(labels)] += delta
+}
+
+// Delete removes metric value with given labels
+func (m *Metrics) Delete(name string, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.series(name), formatLabels(labels))
+}
+
+// series returns metric values map, lock must be held
+func (m *Metrics) series(name string) map[string]float64 {
+	s, ok := m.values[name]
+	if !ok {
+		s = make(map[string]float64)
+		m.values[name] = s
+	}
+	return s
+}
+
+// ServeHTTP writes metrics in prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.values))
+	for k := range m.values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		if info, ok := m.info[name]; ok {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, info.help, name, info.kind)
+		}
+		series := m.values[name]
+		keys := make([]string, 0, len(series))
+		for k := range series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, k, series[k])
+		}
+	}
+}
+
+// formatLabels packs label pairs into prometheus label set
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], v))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package webtail
+
+import (
+	"os"
+)
+
+// fileOwner returns empty string, owner is not supported
+func fileOwner(_ os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package webtail
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// owners caches user names by uid
+var owners sync.Map
+
+// fileOwner returns file owner name or uid
+func fileOwner(f os.FileInfo) string {
+	st, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	uid := strconv.FormatUint(uint64(st.Uid), 10)
+	if name, ok := owners.Load(uid); ok {
+		return name.(string)
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	owners.Store(uid, name)
+	return name
+}
@@ -0,0 +1,114 @@
+package webtail
+
+// This file holds log line decoders and parsers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// lineParser returns fields of parsed line or nil if line does not fit format
+type lineParser func(line string) map[string]interface{}
+
+// parsers holds known line parsers
+// ErrUnsupportedEncoding is returned for encodings which can't be decoded by lines
+var ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+var parsers = map[string]lineParser{
+	"":         nil,
+	"json":     parseJSON,
+	"logfmt":   parseLogfmt,
+	"combined": parseCombined,
+}
+
+// combinedLine matches access log line in common or combined format
+var combinedLine = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "(\S+) (\S+)(?: (\S+))?" (\d{3}) (\d+|-)(?: "([^"]*)" "([^"]*)")?`)
+
+// combinedFields holds field names of combinedLine groups
+var combinedFields = []string{"", "remote_addr", "user", "time", "method", "path", "protocol", "status", "bytes", "referer", "user_agent"}
+
+// lineDecoder returns decoder for charset name
+// UTF-16 is not supported because lines are split by newline byte before decoding
+func lineDecoder(name string) (*encoding.Decoder, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	if canonical, _ := htmlindex.Name(enc); strings.HasPrefix(canonical, "utf-16") {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, name)
+	}
+	return enc.NewDecoder(), nil
+}
+
+// parseJSON parses line as JSON object
+func parseJSON(line string) map[string]interface{} {
+	rv := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &rv); err != nil {
+		return nil
+	}
+	return rv
+}
+
+// parseLogfmt parses key=value pairs, values may be quoted
+func parseLogfmt(line string) map[string]interface{} {
+	rv := map[string]interface{}{}
+	for {
+		line = strings.TrimLeft(line, " \t")
+		eq := strings.IndexAny(line, "= \t")
+		if eq < 0 {
+			break
+		}
+		if line[eq] != '=' || eq == 0 {
+			// skip bare word
+			line = line[max(eq, 1):]
+			continue
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+		var val string
+		if strings.HasPrefix(line, `"`) {
+			end := 1
+			for end < len(line) && (line[end] != '"' || line[end-1] == '\\') {
+				end++
+			}
+			val = strings.ReplaceAll(line[1:min(end, len(line))], `\"`, `"`)
+			line = line[min(end+1, len(line)):]
+		} else {
+			sp := strings.IndexAny(line, " \t")
+			if sp < 0 {
+				sp = len(line)
+			}
+			val = line[:sp]
+			line = line[sp:]
+		}
+		rv[key] = val
+	}
+	if len(rv) == 0 {
+		return nil
+	}
+	return rv
+}
+
+// parseCombined parses access log line in common or combined format
+func parseCombined(line string) map[string]interface{} {
+	m := combinedLine.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	rv := make(map[string]interface{}, len(combinedFields))
+	for i, name := range combinedFields {
+		if name != "" && m[i] != "" && m[i] != "-" {
+			rv[name] = m[i]
+		}
+	}
+	return rv
+}
@@ -0,0 +1,110 @@
+package webtail
+
+// This file holds polling indexer methods
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errScanAborted returned when worker stops while scan
+var errScanAborted = errors.New("scan aborted")
+
+// runPoll rescans root periodically and sends changes found
+func (iw indexWorker) runPoll(readyChan chan struct{}) {
+	known := iw.known
+	readyChan <- struct{}{}
+	ticker := time.NewTicker(iw.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			items, err := iw.scan(known)
+			if errors.Is(err, errScanAborted) {
+				return
+			}
+			if err != nil {
+				iw.log.Error(err, "Scan")
+				continue
+			}
+			iw.sendChanges(known, items)
+			known = items
+		case <-iw.quit:
+			iw.log.Info("Exiting")
+			return
+		}
+	}
+}
+
+// pollSeed copies attrs compared by poller from index items of root
+// Items are copied because index is changed by hub
+func pollSeed(index IndexItemAttrStore, root LogRoot) IndexItemAttrStore {
+	rv := make(IndexItemAttrStore)
+	prefix := root.prefix()
+	for k, v := range index {
+		if prefix == "" || strings.HasPrefix(k, prefix) {
+			rv[k] = &IndexItemAttr{ModTime: v.ModTime, Size: v.Size, Unreadable: v.Unreadable, Mode: v.Mode,
+				Compressed: v.Compressed, Binary: v.Binary, Encoding: v.Encoding, Parser: v.Parser}
+		}
+	}
+	return rv
+}
+
+// sendChanges sends events for items changed between scans
+func (iw indexWorker) sendChanges(known, items IndexItemAttrStore) {
+	for k, v := range items {
+		if old, ok := known[k]; !ok || !old.ModTime.Equal(v.ModTime) || old.Size != v.Size ||
+			old.Unreadable != v.Unreadable {
+			iw.send(&IndexItemEvent{Name: k, Root: iw.root.Name, IndexItemAttr: *v})
+		}
+	}
+	for k := range known {
+		if _, ok := items[k]; !ok {
+			iw.send(&IndexItemEvent{Name: k, Root: iw.root.Name, Deleted: true})
+		}
+	}
+}
+
+// scan walks root with rate limit
+// Content of known files is not detected again unless they were truncated
+func (iw indexWorker) scan(known IndexItemAttrStore) (IndexItemAttrStore, error) {
+	items := make(IndexItemAttrStore)
+	count := 0
+	start := time.Now()
+	err := filepath.WalkDir(iw.root.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		if iw.rate > 0 {
+			count++
+			if count == iw.rate {
+				// batch limit reached, wait till the end of second
+				select {
+				case <-time.After(time.Second - time.Since(start)):
+				case <-iw.quit:
+					return errScanAborted
+				}
+				count, start = 0, time.Now()
+			}
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := d.Info()
+		if err != nil {
+			// removed while scan
+			return nil
+		}
+		name := iw.itemName(path)
+		items[name] = fileAttr(path, f, known[name])
+		return nil
+	})
+	return items, err
+}
@@ -0,0 +1,71 @@
+package webtail
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollIndexer(t *testing.T) {
+	dir := t.TempDir()
+	out := make(chan *IndexItemEvent)
+	quit := make(chan struct{})
+	readyChan := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	go indexWorker{
+		out:  out,
+		quit: quit,
+		log:  logr.Discard(),
+		root: LogRoot{Name: "nfs", Path: dir},
+		poll: 10 * time.Millisecond,
+		rate: 100,
+	}.run(readyChan, &wg)
+	<-readyChan
+
+	err := os.WriteFile(filepath.Join(dir, "new.log"), []byte("row\n"), 0o600)
+	require.NoError(t, err)
+	event := <-out
+	assert.Equal(t, "nfs/new.log", event.Name)
+	assert.Equal(t, "nfs", event.Root)
+	assert.Equal(t, int64(4), event.Size)
+
+	err = os.Remove(filepath.Join(dir, "new.log"))
+	require.NoError(t, err)
+	event = <-out
+	assert.Equal(t, &IndexItemEvent{Name: "nfs/new.log", Root: "nfs", Deleted: true}, event)
+	close(quit)
+	wg.Wait()
+}
+
+func TestPollIndexerSeed(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.log"), []byte("row\n"), 0o600))
+	root := LogRoot{Name: "nfs", Path: dir}
+	index := make(IndexItemAttrStore)
+	require.NoError(t, loadIndex(index, root, time.Now().Add(time.Second)))
+	out := make(chan *IndexItemEvent)
+	quit := make(chan struct{})
+	readyChan := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	go indexWorker{
+		out:   out,
+		quit:  quit,
+		log:   logr.Discard(),
+		root:  root,
+		poll:  10 * time.Millisecond,
+		known: pollSeed(index, root),
+	}.run(readyChan, &wg)
+	<-readyChan
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new.log"), []byte("row\n"), 0o600))
+	event := <-out
+	assert.Equal(t, "nfs/new.log", event.Name, "loaded file is not sent again")
+	close(quit)
+	wg.Wait()
+}
@@ -0,0 +1,156 @@
+package webtail
+
+// This file holds index query methods
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Index query sort fields
+const (
+	SortName  = "name"
+	SortMTime = "mtime"
+	SortSize  = "size"
+)
+
+// Index query errors
+var (
+	ErrBadGlob   = errors.New("bad glob")
+	ErrBadSort   = errors.New("unknown sort field")
+	ErrBadCursor = errors.New("bad cursor")
+)
+
+// IndexQuery holds index filter, sort and paging options
+type IndexQuery struct {
+	Prefix string `json:"prefix,omitempty"`
+	Glob   string `json:"glob,omitempty"`
+	Sort   string `json:"sort,omitempty"` // name (default), mtime or size
+	Desc   bool   `json:"desc,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // 0 - no limit
+	Offset int    `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"` // Next value from previous page, Offset is ignored if set
+}
+
+// IndexPage holds index query result
+type IndexPage struct {
+	Items []IndexItemEvent
+	Total int    // items matched
+	Next  string // cursor of the next page
+}
+
+// Validate checks query fields
+func (q *IndexQuery) Validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.Glob != "" && !ValidGlob(q.Glob) {
+		return ErrBadGlob
+	}
+	switch q.Sort {
+	case "", SortName, SortMTime, SortSize:
+	default:
+		return ErrBadSort
+	}
+	if q.Cursor != "" {
+		if _, _, err := decodeCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match checks if index item name fits query filter
+func (q *IndexQuery) Match(name string) bool {
+	if q == nil {
+		return true
+	}
+	return strings.HasPrefix(name, q.Prefix) && (q.Glob == "" || MatchGlob(q.Glob, name))
+}
+
+// Run returns page of items matched by query
+// Items must be sorted by name
+func (q *IndexQuery) Run(items []IndexItemEvent) *IndexPage {
+	rv := &IndexPage{}
+	for _, item := range items {
+		if q.Match(item.Name) {
+			rv.Items = append(rv.Items, item)
+		}
+	}
+	rv.Total = len(rv.Items)
+	if q.Sort != "" && q.Sort != SortName {
+		slices.SortStableFunc(rv.Items, func(a, b IndexItemEvent) int {
+			return cmp.Compare(q.sortKey(&a), q.sortKey(&b))
+		})
+	}
+	if q.Desc {
+		slices.Reverse(rv.Items)
+	}
+	start := min(max(q.Offset, 0), len(rv.Items))
+	if q.Cursor != "" {
+		key, name, _ := decodeCursor(q.Cursor)
+		start, _ = slices.BinarySearchFunc(rv.Items, key, func(item IndexItemEvent, _ int64) int {
+			if c := q.compareKey(&item, key, name); c != 0 {
+				return c
+			}
+			// cursor item itself belongs to previous page
+			return -1
+		})
+	}
+	rv.Items = rv.Items[start:]
+	if q.Limit > 0 && len(rv.Items) > q.Limit {
+		rv.Items = rv.Items[:q.Limit]
+		last := &rv.Items[q.Limit-1]
+		rv.Next = encodeCursor(q.sortKey(last), last.Name)
+	}
+	return rv
+}
+
+// sortKey returns numeric sort value of item, 0 if items are sorted by name
+func (q *IndexQuery) sortKey(item *IndexItemEvent) int64 {
+	switch q.Sort {
+	case SortMTime:
+		return item.ModTime.UnixNano()
+	case SortSize:
+		return item.Size
+	}
+	return 0
+}
+
+// compareKey compares item position with (key, name) in query order
+func (q *IndexQuery) compareKey(item *IndexItemEvent, key int64, name string) int {
+	c := cmp.Compare(q.sortKey(item), key)
+	if c == 0 {
+		c = strings.Compare(item.Name, name)
+	}
+	if q.Desc {
+		c = -c
+	}
+	return c
+}
+
+// encodeCursor packs sort key and name of the last page item
+func encodeCursor(key int64, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key, 10) + "\n" + name))
+}
+
+// decodeCursor unpacks cursor
+func decodeCursor(cursor string) (int64, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrBadCursor
+	}
+	key, name, ok := strings.Cut(string(data), "\n")
+	if !ok {
+		return 0, "", ErrBadCursor
+	}
+	k, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return 0, "", ErrBadCursor
+	}
+	return k, name, nil
+}
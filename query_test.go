@@ -0,0 +1,46 @@
+package webtail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexQuery(t *testing.T) {
+	now := time.Now()
+	items := []IndexItemEvent{
+		{Name: "a.log", IndexItemAttr: IndexItemAttr{Size: 30, ModTime: now}},
+		{Name: "b.log", IndexItemAttr: IndexItemAttr{Size: 10, ModTime: now.Add(-time.Hour)}},
+		{Name: "c.txt", IndexItemAttr: IndexItemAttr{Size: 20, ModTime: now}},
+		{Name: "dir/d.log", IndexItemAttr: IndexItemAttr{Size: 10, ModTime: now}},
+	}
+	names := func(page *IndexPage) []string {
+		rv := []string{}
+		for _, item := range page.Items {
+			rv = append(rv, item.Name)
+		}
+		return rv
+	}
+	q := &IndexQuery{Glob: "*.log", Sort: SortSize, Limit: 2}
+	require.NoError(t, q.Validate())
+	page := q.Run(items)
+	assert.Equal(t, []string{"b.log", "dir/d.log"}, names(page))
+	assert.Equal(t, 3, page.Total)
+
+	q.Cursor = page.Next
+	page = q.Run(items)
+	assert.Equal(t, []string{"a.log"}, names(page))
+	assert.Empty(t, page.Next)
+
+	q = &IndexQuery{Sort: SortMTime, Desc: true, Offset: 1, Limit: 2}
+	assert.Equal(t, []string{"c.txt", "a.log"}, names(q.Run(items)))
+
+	q = &IndexQuery{Prefix: "dir/"}
+	assert.Equal(t, []string{"dir/d.log"}, names(q.Run(items)))
+	assert.False(t, q.Match("a.log"))
+
+	assert.ErrorIs(t, (&IndexQuery{Sort: "owner"}).Validate(), ErrBadSort)
+	assert.ErrorIs(t, (&IndexQuery{Cursor: "!"}).Validate(), ErrBadCursor)
+}
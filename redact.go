@@ -0,0 +1,246 @@
+package webtail
+
+// This file holds secret redaction of tailed lines
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redactReplace is a default replacement of secret
+const redactReplace = "[REDACTED]"
+
+// metricRedactions is a redactions counter name
+const metricRedactions = "webtail_redactions_total"
+
+// RedactRule holds secret pattern of files matched by Glob
+// Either Pattern or Detector must be set
+type RedactRule struct {
+	Name     string `yaml:"name"`     // metrics label, Detector or Pattern if empty
+	Root     string `yaml:"root"`     // root name, rule is applied to all roots if empty
+	Glob     string `yaml:"glob"`     // file path relative to root, all files if empty
+	Pattern  string `yaml:"pattern"`  // secret regexp
+	Replace  string `yaml:"replace"`  // replacement, may refer to Pattern groups as ${1}
+	Detector string `yaml:"detector"` // built-in detector name, see detectors
+}
+
+// redactor holds compiled redaction rule
+type redactor struct {
+	name    string
+	re      *regexp.Regexp
+	replace string
+	// match validator, nil if any match is a secret
+	valid func(string) bool
+}
+
+// detectors holds built-in secret detectors
+var detectors = map[string]redactor{
+	"bearer":      {re: regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), replace: "${1}" + redactReplace},
+	"url_auth":    {re: regexp.MustCompile(`(://[^:/\s@]+:)[^@\s/]+@`), replace: "${1}" + redactReplace + "@"},
+	"password":    {re: regexp.MustCompile(`(?i)\b((?:password|passwd|pwd|secret|token|api_?key)=)[^&\s"]+`), replace: "${1}" + redactReplace},
+	"card":        {re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), replace: redactReplace, valid: luhnValid},
+	"email":       {re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), replace: redactReplace},
+	"aws_key":     {re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), replace: redactReplace},
+	"jwt":         {re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), replace: redactReplace},
+	"private_key": {re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`), replace: redactReplace},
+}
+
+// checkRedactRule checks redaction rule values
+func checkRedactRule(rule RedactRule) error {
+	if rule.Glob != "" && !ValidGlob(rule.Glob) {
+		return fmt.Errorf("bad glob %q", rule.Glob)
+	}
+	_, err := newRedactor(rule)
+	return err
+}
+
+// newRedactor compiles redaction rule
+func newRedactor(rule RedactRule) (*redactor, error) {
+	if (rule.Pattern == "") == (rule.Detector == "") {
+		return nil, fmt.Errorf("either pattern or detector required")
+	}
+	var rv redactor
+	if rule.Detector != "" {
+		d, ok := detectors[rule.Detector]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", rule.Detector)
+		}
+		rv = d
+		rv.name = rule.Detector
+	} else {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern: %w", err)
+		}
+		rv = redactor{name: rule.Pattern, re: re, replace: redactReplace}
+	}
+	if rule.Name != "" {
+		rv.name = rule.Name
+	}
+	if rule.Replace != "" {
+		rv.replace = rule.Replace
+	}
+	return &rv, nil
+}
+
+// apply replaces secrets in line and returns replacements count
+func (r *redactor) apply(line string) (string, int) {
+	n := 0
+	rv := r.re.ReplaceAllStringFunc(line, func(m string) string {
+		if r.valid != nil && !r.valid(m) {
+			return m
+		}
+		n++
+		idx := r.re.FindStringSubmatchIndex(m)
+		if idx == nil {
+			return r.replace
+		}
+		return string(r.re.ExpandString(nil, r.replace, m, idx))
+	})
+	return rv, n
+}
+
+// luhnValid checks card number checksum
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// redactRules returns redaction rules of channel
+func (ts *TailService) redactRules(channel string) []RedactRule {
+	root, rel, _ := ts.channelRoot(channel)
+	var rv []RedactRule
+	for _, rule := range ts.rules.Redact {
+		if (rule.Root == "" || rule.Root == root.Name) && (rule.Glob == "" || MatchGlob(rule.Glob, rel)) {
+			rv = append(rv, rule)
+		}
+	}
+	return rv
+}
+
+// newRedactors compiles redaction rules, rules are checked in validate
+func newRedactors(rules []RedactRule) []*redactor {
+	rv := make([]*redactor, 0, len(rules))
+	for _, rule := range rules {
+		if r, err := newRedactor(rule); err == nil {
+			rv = append(rv, r)
+		}
+	}
+	return rv
+}
+
+// redactLine applies redactors to line and counts redactions in metrics
+func redactLine(redactors []*redactor, line string, metrics *Metrics) (string, bool) {
+	changed := false
+	for _, r := range redactors {
+		var n int
+		if line, n = r.apply(line); n > 0 {
+			metrics.Add(metricRedactions, float64(n), "rule", r.name)
+			changed = true
+		}
+	}
+	return line, changed
+}
+
+// redactExempt checks if client gets lines without redaction
+func (ts *TailService) redactExempt(client *Client) bool {
+	exempt := ts.rules.RedactExempt
+	if len(exempt) == 0 || client.user == "" {
+		return false
+	}
+	if slices.Contains(exempt, client.user) {
+		return true
+	}
+	for _, g := range client.groups {
+		if slices.Contains(exempt, "group:"+g) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGroups splits groups header value
+func parseGroups(value string) []string {
+	var rv []string
+	for _, g := range strings.Split(value, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			rv = append(rv, g)
+		}
+	}
+	return rv
+}
+
+// checkProxies checks trusted proxy networks
+func checkProxies(nets []string) error {
+	for _, s := range nets {
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return fmt.Errorf("bad trusted proxy %q: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// trustedProxy checks if request remote address belongs to one of trusted proxy networks
+func trustedProxy(nets []string, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, s := range nets {
+		if p, err := netip.ParsePrefix(s); err == nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedAddr returns client address set by trusted proxy, empty if headers are not set
+// The rightmost X-Forwarded-For address which is not a trusted proxy is used
+func forwardedAddr(nets []string, header http.Header) string {
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		addrs := strings.Split(strings.Join(values, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			if addr := strings.TrimSpace(addrs[i]); addr != "" && !trustedProxy(nets, addr) {
+				return addr
+			}
+		}
+		// chain holds proxies only
+		return strings.TrimSpace(addrs[0])
+	}
+	return strings.TrimSpace(header.Get("X-Real-IP"))
+}
+
+// bufferItem returns buffered line by sequence number, original one if client is exempt from redaction
+func (ts *TailService) bufferItem(channel string, seq uint64, exempt bool) []byte {
+	w := ts.workers[channel]
+	if exempt {
+		if raw, ok := w.Raw[seq]; ok {
+			return raw
+		}
+	}
+	return w.Buffer[seq-(w.Seq-uint64(len(w.Buffer))+1)]
+}
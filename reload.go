@@ -0,0 +1,105 @@
+package webtail
+
+// This file holds config reload methods
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+)
+
+// ErrServiceStopped is returned by Reload if hub is not running
+var ErrServiceStopped = errors.New("service is stopped")
+
+// reloadRequest holds checked config for running hub
+type reloadRequest struct {
+	cfg   *Config
+	rules *Rules
+	roots []LogRoot
+	done  chan struct{}
+}
+
+// Reload checks config and applies it to running service
+func (wt *Service) Reload(cfg *Config) error {
+	cfg = cfg.clone()
+	rules, roots, err := prepareConfig(cfg)
+	if err != nil {
+		return err
+	}
+	req := &reloadRequest{cfg: cfg, rules: rules, roots: roots, done: make(chan struct{})}
+	select {
+	case wt.hub.reload <- req:
+	case <-wt.hub.done:
+		return ErrServiceStopped
+	}
+	<-req.done
+	return nil
+}
+
+// applyConfig replaces config of running hub
+// Index is reloaded if roots or access rules changed, channels which are not allowed anymore are detached
+// and workers with changed settings are restarted
+func (h *Hub) applyConfig(req *reloadRequest) {
+	defer close(req.done)
+	ts := h.workers
+	indexChanged := !slices.Equal(ts.roots, req.roots) || !reflect.DeepEqual(ts.rules.Access, req.rules.Access)
+	// config is replaced, not changed, because http handlers read the previous one
+	ts.Config = req.cfg
+	h.config.Store(req.cfg)
+	ts.rules = req.rules
+	ts.healthRules.Store(&req.rules.Health)
+	ts.roots = req.roots
+	h.log.Info("Config reloaded", "index_changed", indexChanged)
+	if indexChanged {
+		ts.WorkerStop("")
+		ts.index = make(IndexItemAttrStore)
+		// expanded directories may not exist under new roots
+		clear(h.trees)
+		ts.IndexerRun(h.index, h.wg)
+		ts.TermSync()
+		// index subscribers have to reload index
+		h.detachChannel("", MsgConfigReloaded)
+	}
+	channels := make([]string, 0, len(ts.workers))
+	for channel := range ts.workers {
+		if channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		if !ts.ChannelExists(channel) {
+			h.detachChannel(channel, MsgUnknownChannel)
+			continue
+		}
+		if ts.TailSettings(channel).Equal(ts.workers[channel].Settings) {
+			continue
+		}
+		h.log.Info("Restart worker", "channel", channel)
+		ts.WorkerStop(channel)
+		readyChan := make(chan struct{})
+		if err := ts.TailerRun(channel, h.receive, readyChan, h.wg); err != nil {
+			h.log.Error(err, "Worker create error")
+			delete(h.alerts.pinned, channel)
+			h.detachChannel(channel, MsgWorkerError)
+			continue
+		}
+		<-readyChan
+		h.filtersReset(channel)
+		if pin, ok := h.alerts.pinned[channel]; ok {
+			// lines of restarted worker are read again
+			pin.from, pin.last = ts.index[channel].Size, 0
+		}
+	}
+	h.alerts = newAlerter(req.rules.Alert, h.alerts)
+	h.alertSync()
+}
+
+// detachChannel unsubscribes all channel clients with given reason
+func (h *Hub) detachChannel(channel, reason string) {
+	data, _ := json.Marshal(TailMessage{Type: "detach", Channel: channel, Data: reason})
+	for client := range h.subscribers[channel] {
+		h.unsubscribe(channel, client)
+		h.send(client, data)
+	}
+}
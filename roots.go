@@ -0,0 +1,127 @@
+package webtail
+
+// This file holds log roots and access rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LogRoot holds named directory with log files
+type LogRoot struct {
+	Name string
+	Path string
+}
+
+// AccessRule holds file visibility rule for root
+// File is hidden if it matches any Deny glob or if Allow is not empty and file matches none of it
+type AccessRule struct {
+	Root  string   `yaml:"root"`
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// prefix returns channel name prefix for root files
+func (root LogRoot) prefix() string {
+	if root.Name == "" {
+		return ""
+	}
+	return root.Name + "/"
+}
+
+// parseRoots parses Config.Roots items (name=path) or uses Config.Root if list is empty
+func parseRoots(cfg *Config) ([]LogRoot, error) {
+	if len(cfg.Roots) == 0 {
+		aPath, err := rootPath(cfg.Root)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Root = aPath
+		return []LogRoot{{Path: aPath}}, nil
+	}
+	rv := make([]LogRoot, 0, len(cfg.Roots))
+	names := make(map[string]bool)
+	for _, item := range cfg.Roots {
+		name, dir, ok := strings.Cut(item, "=")
+		if !ok || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("root %q: name=path required", item)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("root %q: duplicate name", name)
+		}
+		names[name] = true
+		aPath, err := rootPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, LogRoot{Name: name, Path: aPath})
+	}
+	return rv, nil
+}
+
+// rootPath checks root dir and returns its absolute path
+func rootPath(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+	return filepath.Abs(dir)
+}
+
+// channelRoot returns root of channel and file path relative to it
+func (ts *TailService) channelRoot(channel string) (LogRoot, string, bool) {
+	for _, root := range ts.roots {
+		if root.Name == "" {
+			return root, channel, true
+		}
+		if rel, ok := strings.CutPrefix(channel, root.prefix()); ok {
+			return root, rel, true
+		}
+	}
+	return LogRoot{}, "", false
+}
+
+// ChannelRoot returns root name of channel
+func (ts *TailService) ChannelRoot(channel string) string {
+	root, _, _ := ts.channelRoot(channel)
+	return root.Name
+}
+
+// IndexAllowed checks if file is visible by access rules
+func (ts *TailService) IndexAllowed(channel string) bool {
+	root, rel, ok := ts.channelRoot(channel)
+	if !ok {
+		return false
+	}
+	for _, rule := range ts.rules.Access {
+		if rule.Root != "" && rule.Root != root.Name {
+			continue
+		}
+		for _, glob := range rule.Deny {
+			if MatchGlob(glob, rel) {
+				return false
+			}
+		}
+		if len(rule.Allow) == 0 {
+			continue
+		}
+		allowed := false
+		for _, glob := range rule.Allow {
+			if MatchGlob(glob, rel) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// channelFile returns file path of channel
+func (ts *TailService) channelFile(channel string) string {
+	root, rel, _ := ts.channelRoot(channel)
+	return filepath.Join(root.Path, filepath.FromSlash(rel))
+}
@@ -0,0 +1,87 @@
+package webtail
+
+// This file holds rotated file chains
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// checkRotation checks rotated file suffix patterns
+func checkRotation(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("rotation: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotationChain returns channel and older files of its rotation set, newest first
+// If channel is a rotated file itself, chain starts from it
+func (ts *TailService) rotationChain(channel string) []string {
+	patterns := ts.TailSettings(channel).Rotation
+	if len(patterns) == 0 {
+		return []string{channel}
+	}
+	suffixes := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		// checked in prepareConfig
+		suffixes[i] = regexp.MustCompile(`^(?:` + p + `)$`)
+	}
+	isRotated := func(base, name string) bool {
+		if len(name) <= len(base) || !strings.HasPrefix(name, base) {
+			return false
+		}
+		for _, re := range suffixes {
+			if re.MatchString(name[len(base):]) {
+				return true
+			}
+		}
+		return false
+	}
+	base := ts.rotationBase(channel, isRotated)
+	var chain []string
+	for name := range ts.index {
+		if isRotated(base, name) {
+			chain = append(chain, name)
+		}
+	}
+	sort.Slice(chain, func(i, j int) bool {
+		a, b := ts.index[chain[i]].ModTime, ts.index[chain[j]].ModTime
+		if a.Equal(b) {
+			return chain[i] < chain[j]
+		}
+		return a.After(b)
+	})
+	if _, ok := ts.index[base]; ok {
+		chain = append([]string{base}, chain...)
+	}
+	for i, name := range chain {
+		if name == channel {
+			return chain[i:]
+		}
+	}
+	return []string{channel}
+}
+
+// rotationBase returns name of current file for rotated one
+// Indexed name is preferred if file name matches several suffixes
+func (ts *TailService) rotationBase(channel string, isRotated func(base, name string) bool) string {
+	rv := channel
+	for i := len(channel) - 1; i > 0 && channel[i-1] != '/'; i-- {
+		base := channel[:i]
+		if !isRotated(base, channel) {
+			continue
+		}
+		if _, ok := ts.index[base]; ok {
+			return base
+		}
+		if rv == channel {
+			rv = base
+		}
+	}
+	return rv
+}
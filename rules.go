@@ -0,0 +1,236 @@
+package webtail
+
+// This file holds per-file rules
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TailRule holds tail settings for files matched by Glob
+// Empty fields are inherited from Config
+type TailRule struct {
+	Root      string   `yaml:"root"` // root name, rule is applied to all roots if empty
+	Glob      string   `yaml:"glob"` // file path relative to root
+	Bytes     *int64   `yaml:"bytes"`
+	Lines     *int     `yaml:"lines"`
+	Split     *int     `yaml:"split"`
+	Poll      *bool    `yaml:"poll"`
+	Parser    string   `yaml:"parser"`
+	Encoding  string   `yaml:"encoding"`
+	Multiline string   `yaml:"multiline"` // regexp of the first line of multiline record
+	Rotation  []string `yaml:"rotation"`  // rotated file name suffixes (regexp)
+	Templates bool     `yaml:"templates"` // group lines into templates
+	Aggregate []string `yaml:"aggregate"` // parsed fields for top-N counters
+	Dedup     string   `yaml:"dedup"`     // collapse repeated lines (exact or masked)
+
+	// compiled Multiline, set by validate
+	multiline *regexp.Regexp
+}
+
+// Rules holds rules file content
+type Rules struct {
+	Tail   []TailRule   `yaml:"tail"`
+	Access []AccessRule `yaml:"access"`
+	Alert  []AlertRule  `yaml:"alert"`
+	Health []HealthRule `yaml:"health"`
+	Redact []RedactRule `yaml:"redact"`
+	// users and groups (as group:name) who get lines without redaction
+	RedactExempt []string `yaml:"redact_exempt"`
+}
+
+// TailSettings holds effective tail settings of file
+type TailSettings struct {
+	Bytes     int64
+	Lines     int
+	Split     int
+	Poll      bool
+	Parser    string
+	Encoding  string
+	Multiline *regexp.Regexp
+	Rotation  []string
+	Templates bool
+	Aggregate []string
+	Dedup     string
+	Redact    []RedactRule
+}
+
+// LoadRules loads rules from yaml file
+func LoadRules(file string) (*Rules, error) {
+	rules := &Rules{}
+	if file == "" {
+		return rules, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("rules parse: %w", err)
+	}
+	if err = rules.validate(); err != nil {
+		return nil, fmt.Errorf("rules check: %w", err)
+	}
+	return rules, nil
+}
+
+// validate checks rules values
+func (r *Rules) validate() error {
+	for i, rule := range r.Tail {
+		if rule.Glob == "" || !ValidGlob(rule.Glob) {
+			return fmt.Errorf("tail rule %d: bad glob %q", i, rule.Glob)
+		}
+		if _, ok := parsers[rule.Parser]; !ok {
+			return fmt.Errorf("tail rule %d: unknown parser %q", i, rule.Parser)
+		}
+		if _, err := lineDecoder(rule.Encoding); err != nil {
+			return fmt.Errorf("tail rule %d: %w", i, err)
+		}
+		if (rule.Bytes != nil && *rule.Bytes < 0) || (rule.Lines != nil && *rule.Lines < 0) || (rule.Split != nil && *rule.Split < 0) {
+			return fmt.Errorf("tail rule %d: bytes, lines and split must not be negative", i)
+		}
+		if rule.Multiline != "" {
+			re, err := regexp.Compile(rule.Multiline)
+			if err != nil {
+				return fmt.Errorf("tail rule %d: multiline: %w", i, err)
+			}
+			r.Tail[i].multiline = re
+		}
+		if err := checkRotation(rule.Rotation); err != nil {
+			return fmt.Errorf("tail rule %d: %w", i, err)
+		}
+		if err := checkDedup(rule.Dedup); err != nil {
+			return fmt.Errorf("tail rule %d: %w", i, err)
+		}
+		if len(rule.Aggregate) > 0 && rule.Parser == "" {
+			return fmt.Errorf("tail rule %d: aggregate requires parser", i)
+		}
+	}
+	for i, rule := range r.Access {
+		for _, glob := range append(rule.Allow, rule.Deny...) {
+			if !ValidGlob(glob) {
+				return fmt.Errorf("access rule %d: bad glob %q", i, glob)
+			}
+		}
+	}
+	names := make(map[string]bool, len(r.Alert))
+	for i, rule := range r.Alert {
+		if err := checkAlertRule(rule); err != nil {
+			return fmt.Errorf("alert rule %d: %w", i, err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("alert rule %d: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	for i, rule := range r.Health {
+		if err := checkHealthRule(rule); err != nil {
+			return fmt.Errorf("health rule %d: %w", i, err)
+		}
+	}
+	for i, rule := range r.Redact {
+		if err := checkRedactRule(rule); err != nil {
+			return fmt.Errorf("redact rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkRoots checks that rules refer to known roots
+func (r *Rules) checkRoots(roots []LogRoot) error {
+	known := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		known[root.Name] = true
+	}
+	for i, rule := range r.Tail {
+		if rule.Root != "" && !known[rule.Root] {
+			return fmt.Errorf("tail rule %d: unknown root %q", i, rule.Root)
+		}
+	}
+	for i, rule := range r.Access {
+		if rule.Root != "" && !known[rule.Root] {
+			return fmt.Errorf("access rule %d: unknown root %q", i, rule.Root)
+		}
+	}
+	for i, rule := range r.Alert {
+		if rule.Root != "" && !known[rule.Root] {
+			return fmt.Errorf("alert rule %d: unknown root %q", i, rule.Root)
+		}
+	}
+	for i, rule := range r.Health {
+		if rule.Root != "" && !known[rule.Root] {
+			return fmt.Errorf("health rule %d: unknown root %q", i, rule.Root)
+		}
+	}
+	for i, rule := range r.Redact {
+		if rule.Root != "" && !known[rule.Root] {
+			return fmt.Errorf("redact rule %d: unknown root %q", i, rule.Root)
+		}
+	}
+	return nil
+}
+
+// TailSettings returns settings for channel from the first matching rule
+func (ts *TailService) TailSettings(channel string) TailSettings {
+	cfg := ts.Config
+	rv := TailSettings{
+		Bytes:    cfg.Bytes,
+		Lines:    cfg.Lines,
+		Split:    cfg.MaxLineSize,
+		Poll:     cfg.Poll,
+		Rotation: cfg.Rotation,
+	}
+	root, rel, _ := ts.channelRoot(channel)
+	for _, rule := range ts.rules.Tail {
+		if (rule.Root != "" && rule.Root != root.Name) || !MatchGlob(rule.Glob, rel) {
+			continue
+		}
+		if rule.Bytes != nil {
+			rv.Bytes = *rule.Bytes
+		}
+		if rule.Lines != nil {
+			rv.Lines = *rule.Lines
+		}
+		if rule.Split != nil {
+			rv.Split = *rule.Split
+		}
+		if rule.Poll != nil {
+			rv.Poll = *rule.Poll
+		}
+		rv.Parser = rule.Parser
+		rv.Encoding = rule.Encoding
+		rv.Multiline = rule.multiline
+		if rule.Rotation != nil {
+			rv.Rotation = rule.Rotation
+		}
+		rv.Templates = rule.Templates
+		rv.Aggregate = rule.Aggregate
+		rv.Dedup = rule.Dedup
+		break
+	}
+	rv.Redact = ts.redactRules(channel)
+	return rv
+}
+
+// Equal reports whether settings are the same
+func (set TailSettings) Equal(other TailSettings) bool {
+	if (set.Multiline == nil) != (other.Multiline == nil) ||
+		(set.Multiline != nil && set.Multiline.String() != other.Multiline.String()) {
+		return false
+	}
+	return set.Bytes == other.Bytes &&
+		set.Lines == other.Lines &&
+		set.Split == other.Split &&
+		set.Poll == other.Poll &&
+		set.Parser == other.Parser &&
+		set.Encoding == other.Encoding &&
+		set.Templates == other.Templates &&
+		slices.Equal(set.Aggregate, other.Aggregate) &&
+		set.Dedup == other.Dedup &&
+		slices.Equal(set.Redact, other.Redact) &&
+		slices.Equal(set.Rotation, other.Rotation)
+}
@@ -0,0 +1,103 @@
+package webtail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.log", "file.log", true},
+		{"*.log", "subdir/another.log", true},
+		{"subdir/*.log", "subdir/another.log", true},
+		{"subdir/*.log", "file.log", false},
+		{"**/another.log", "subdir/another.log", true},
+		{"**/another.log", "another.log", true},
+		{"subdir/**", "subdir/a/b.log", true},
+		{"nginx/access*", "nginx/error.log", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, MatchGlob(tt.pattern, tt.name), tt.pattern+" "+tt.name)
+	}
+}
+
+func TestTailSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yml")
+	err := os.WriteFile(file, []byte(`
+tail:
+  - glob: subdir/*.log
+    lines: 5
+    poll: true
+    parser: logfmt
+    multiline: '^\S'
+  - glob: "*.log"
+    bytes: 100
+`), 0o600)
+	require.NoError(t, err)
+
+	cfg := &Config{Root: "testdata", Lines: 100, Bytes: 5000, MaxLineSize: 180, Rules: file}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+
+	set := ts.TailSettings("subdir/another.log")
+	assert.Equal(t, 5, set.Lines)
+	assert.Equal(t, int64(5000), set.Bytes)
+	assert.True(t, set.Poll)
+	assert.Equal(t, "logfmt", set.Parser)
+	assert.NotNil(t, set.Multiline)
+
+	set = ts.TailSettings("file.log")
+	assert.Equal(t, int64(100), set.Bytes)
+	assert.Equal(t, 100, set.Lines)
+	assert.Nil(t, set.Multiline)
+
+	assert.Equal(t, map[string]interface{}{"level": "info", "msg": "a \"b\" c"},
+		parsers["logfmt"](`level=info bare msg="a \"b\" c"`))
+
+	err = os.WriteFile(file, []byte("tail:\n  - glob: '*'\n    parser: xml\n"), 0o600)
+	require.NoError(t, err)
+	_, err = LoadRules(file)
+	assert.EqualError(t, err, `rules check: tail rule 0: unknown parser "xml"`)
+
+	err = os.WriteFile(file, []byte("tail:\n  - glob: '*'\n    lines: -1\n"), 0o600)
+	require.NoError(t, err)
+	_, err = LoadRules(file)
+	assert.EqualError(t, err, `rules check: tail rule 0: bytes, lines and split must not be negative`)
+}
+
+func TestRoots(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yml")
+	err := os.WriteFile(file, []byte(`
+tail:
+  - root: sub
+    glob: "*.log"
+    lines: 7
+access:
+  - root: main
+    deny: ["subdir/**"]
+`), 0o600)
+	require.NoError(t, err)
+
+	cfg := &Config{Roots: []string{"main=testdata", "sub=testdata/subdir"}, Lines: 100, Rules: file}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "sub", ts.ChannelRoot("sub/another.log"))
+	assert.Equal(t, 7, ts.TailSettings("sub/another.log").Lines)
+	assert.Equal(t, 100, ts.TailSettings("main/file.log").Lines)
+	assert.True(t, ts.IndexAllowed("main/file.log"))
+	assert.False(t, ts.IndexAllowed("main/subdir/another.log"))
+	assert.False(t, ts.IndexAllowed("other/file.log"))
+
+	cfg.Roots = []string{"main=testdata", "main=testdata/subdir"}
+	_, err = NewTailService(logr.Discard(), cfg)
+	assert.EqualError(t, err, `root "main": duplicate name`)
+}
@@ -0,0 +1,615 @@
+package webtail
+
+// This file holds full-text search across files
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+// Search limits
+const (
+	maxSearchContext = 10  // max context lines
+	searchBatch      = 100 // max hits per result message
+)
+
+// Search errors
+var (
+	ErrSearchPattern = errors.New("empty pattern")
+	ErrSearchRunning = errors.New("search is running")
+	ErrSearchUnknown = errors.New("unknown search")
+)
+
+// SearchQuery holds search request
+type SearchQuery struct {
+	ID         string    `json:"id,omitempty"` // used in result messages and cancel request
+	Pattern    string    `json:"pattern"`
+	Regexp     bool      `json:"regexp,omitempty"` // Pattern is regexp, literal otherwise
+	Term       bool      `json:"term,omitempty"`   // Pattern is a list of words, lines with all of them match. Only term queries use term index
+	IgnoreCase bool      `json:"icase,omitempty"`
+	Glob       string    `json:"glob,omitempty"`    // channel name pattern, all files if empty
+	Since      time.Time `json:"since,omitempty"`   // skip files modified and lines logged before
+	Until      time.Time `json:"until,omitempty"`   // skip lines logged after
+	Context    int       `json:"context,omitempty"` // lines before and after hit
+	Limit      int       `json:"limit,omitempty"`   // max hits, 0 - Config.SearchHits
+}
+
+// SearchHit holds found line with context
+type SearchHit struct {
+	Offset int64    `json:"offset"` // file offset after the line
+	Line   string   `json:"line"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// SearchMessage holds outgoing search results (search_result) or final stats (search_done)
+type SearchMessage struct {
+	Type     string      `json:"type"`
+	ID       string      `json:"id,omitempty"`
+	File     string      `json:"file,omitempty"`
+	Data     []SearchHit `json:"data,omitempty"`
+	Files    int         `json:"files,omitempty"`   // files scanned
+	Indexed  int         `json:"indexed,omitempty"` // files skipped by term index
+	Hits     int         `json:"hits,omitempty"`
+	Canceled bool        `json:"canceled,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// searchRequest holds search preparation request to hub
+type searchRequest struct {
+	query *SearchQuery
+	job   *searchJob
+	err   error
+	done  chan struct{}
+}
+
+// searchFile holds file to search in
+type searchFile struct {
+	name       string
+	path       string
+	encoding   string
+	parser     string
+	compressed bool
+	redact     []RedactRule
+}
+
+// searchJob holds prepared search
+type searchJob struct {
+	query   *SearchQuery
+	re      *regexp.Regexp
+	terms   []string // words of term query
+	index   *TermIndex
+	files   []searchFile
+	limit   int
+	limiter *searchLimiter
+	metrics *Metrics
+	// lines are not redacted
+	exempt  bool
+	hits    atomic.Int64
+	scanned atomic.Int64
+	skipped atomic.Int64
+	// serializes emit calls
+	mu sync.Mutex
+}
+
+// searchLimiter holds worker and I/O budget shared by all searches
+type searchLimiter struct {
+	workers chan struct{}
+	mu      sync.Mutex
+	rate    int64
+	next    time.Time
+}
+
+// newSearchLimiter creates search limiter
+func newSearchLimiter(workers int, rate int64) *searchLimiter {
+	return &searchLimiter{workers: make(chan struct{}, max(workers, 1)), rate: rate}
+}
+
+// wait blocks until n bytes may be read
+func (l *searchLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedReader is a reader limited by search I/O budget
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *searchLimiter
+}
+
+// Read reads data and waits for budget
+func (lr limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if e := lr.limiter.wait(lr.ctx, n); e != nil {
+			return n, e
+		}
+	}
+	return n, err
+}
+
+// prepareSearch checks query and selects files
+func (ts *TailService) prepareSearch(query *SearchQuery) (*searchJob, error) {
+	if query.Pattern == "" {
+		return nil, ErrSearchPattern
+	}
+	if query.Glob != "" && !ValidGlob(query.Glob) {
+		return nil, ErrBadGlob
+	}
+	job := &searchJob{query: query, limit: ts.Config.SearchHits, limiter: ts.searchLimiter, metrics: ts.metrics}
+	if query.Term {
+		if job.terms = Tokenize(query.Pattern); len(job.terms) == 0 {
+			return nil, ErrSearchPattern
+		}
+		job.index = ts.terms
+	} else {
+		var err error
+		if job.re, err = compilePattern(query.Pattern, query.Regexp, query.IgnoreCase); err != nil {
+			return nil, err
+		}
+	}
+	if query.Limit > 0 && (job.limit == 0 || query.Limit < job.limit) {
+		job.limit = query.Limit
+	}
+	query.Context = min(max(query.Context, 0), maxSearchContext)
+	for name, attr := range ts.index {
+		if attr.Unreadable || (attr.Binary && attr.Compressed == "") || attr.Size == 0 ||
+			(query.Glob != "" && !MatchGlob(query.Glob, name)) ||
+			(!query.Since.IsZero() && attr.ModTime.Before(query.Since)) {
+			continue
+		}
+		job.files = append(job.files, searchFile{
+			name:       name,
+			path:       ts.channelFile(name),
+			encoding:   attr.Encoding,
+			parser:     attr.Parser,
+			compressed: attr.Compressed != "",
+			redact:     ts.redactRules(name),
+		})
+	}
+	sort.Slice(job.files, func(i, j int) bool { return job.files[i].name < job.files[j].name })
+	return job, nil
+}
+
+// run scans files concurrently and calls emit for results
+// emit returns false if results are not needed anymore
+func (job *searchJob) run(ctx context.Context, emit func(*SearchMessage) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	send := func(msg *SearchMessage) {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		if ctx.Err() == nil && !emit(msg) {
+			cancel()
+		}
+	}
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		jobErr  error
+	)
+	for _, f := range job.files {
+		acquired := false
+		select {
+		case job.limiter.workers <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if !acquired {
+			break
+		}
+		if job.full() {
+			<-job.limiter.workers
+			break
+		}
+		wg.Add(1)
+		go func(f searchFile) {
+			defer func() {
+				<-job.limiter.workers
+				wg.Done()
+			}()
+			if err := job.scan(ctx, f, send); err != nil && ctx.Err() == nil {
+				errOnce.Do(func() { jobErr = err })
+			}
+		}(f)
+	}
+	wg.Wait()
+	done := &SearchMessage{
+		Type:     "search_done",
+		ID:       job.query.ID,
+		Files:    int(job.scanned.Load()),
+		Indexed:  int(job.skipped.Load()),
+		Hits:     int(job.hits.Load()),
+		Canceled: ctx.Err() != nil,
+	}
+	if job.full() {
+		// hits over limit were skipped
+		done.Hits = job.limit
+	}
+	if jobErr != nil {
+		done.Error = jobErr.Error()
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	emit(done)
+}
+
+// full reports if hit limit is reached
+func (job *searchJob) full() bool {
+	return job.limit > 0 && job.hits.Load() >= int64(job.limit)
+}
+
+// scan searches in file
+// Term query uses term index to skip file or its parts which do not contain terms
+func (job *searchJob) scan(ctx context.Context, f searchFile, send func(*SearchMessage)) error {
+	var plan *termPlan
+	if job.index != nil {
+		plan = job.index.Lookup(f.name, job.terms, f.compressed)
+	}
+	var ranges []byteRange
+	if plan == nil || (f.compressed && len(plan.blocks) > 0) {
+		ranges = []byteRange{{0, -1}}
+	} else if !f.compressed {
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			return err
+		}
+		ranges = plan.ranges(fi.Size())
+	}
+	if len(ranges) == 0 {
+		job.skipped.Add(1)
+		return nil
+	}
+	r, _, err := openLog(f.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	decoder, _ := lineDecoder(f.encoding)
+	fs := &fileScan{job: job, file: f.name, send: send, decoder: decoder, parser: parsers[f.parser]}
+	if !job.exempt {
+		fs.redactors = newRedactors(f.redact)
+	}
+	job.scanned.Add(1)
+	for _, rng := range ranges {
+		if rng.start == 0 {
+			err = fs.scan(ctx, limitedReader{ctx: ctx, r: r, limiter: job.limiter}, 0, rng.end)
+		} else {
+			// plain file, read previous byte to find out if the first line is complete
+			fh := r.(*logReader).file
+			sr := io.NewSectionReader(fh, rng.start-1, math.MaxInt64-rng.start)
+			err = fs.scan(ctx, limitedReader{ctx: ctx, r: sr, limiter: job.limiter}, rng.start-1, rng.end)
+		}
+		if err != nil || ctx.Err() != nil || job.full() {
+			break
+		}
+	}
+	fs.flush()
+	return err
+}
+
+// byteRange holds file part, end = -1 means end of file
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// ranges returns parts of file for scan
+func (plan *termPlan) ranges(size int64) []byteRange {
+	var rv []byteRange
+	for _, b := range plan.blocks {
+		start := int64(b) * termBlockSize
+		end := min(start+termBlockSize, plan.offset)
+		if n := len(rv); n > 0 && rv[n-1].end == start {
+			rv[n-1].end = end
+			continue
+		}
+		rv = append(rv, byteRange{start, end})
+	}
+	if plan.offset >= size {
+		return rv
+	}
+	// not indexed tail
+	if n := len(rv); n > 0 && rv[n-1].end == plan.offset {
+		rv[n-1].end = -1
+	} else {
+		rv = append(rv, byteRange{plan.offset, -1})
+	}
+	return rv
+}
+
+// fileScan holds file scan state
+type fileScan struct {
+	job     *searchJob
+	file    string
+	send    func(*SearchMessage)
+	decoder *encoding.Decoder
+	parser  lineParser
+	// lines are redacted before matching, so secrets can not be found
+	redactors []*redactor
+	batch     []SearchHit
+}
+
+// flush sends collected hits
+func (fs *fileScan) flush() {
+	if len(fs.batch) > 0 {
+		fs.send(&SearchMessage{Type: "search_result", ID: fs.job.query.ID, File: fs.file, Data: fs.batch})
+		fs.batch = nil
+	}
+}
+
+// ready adds hit into batch
+func (fs *fileScan) ready(hit *SearchHit) {
+	fs.batch = append(fs.batch, *hit)
+	if len(fs.batch) >= searchBatch {
+		fs.flush()
+	}
+}
+
+// scan searches lines started before end offset (-1 - all lines)
+// If base > 0, the first line is skipped as partial
+func (fs *fileScan) scan(ctx context.Context, r io.Reader, base, end int64) error {
+	job := fs.job
+	var (
+		before  []string     // context ring
+		pending []*SearchHit // hits waiting for after context
+	)
+	n := job.query.Context
+	err := scanLines(r, base, 0, func(start, stop int64, text string) bool {
+		if base > 0 && start == base {
+			// partial line
+			return true
+		}
+		if end >= 0 && start >= end {
+			return false
+		}
+		text, _ = redactLine(fs.redactors, decodeLine(fs.decoder, text), job.metrics)
+		for len(pending) > 0 && len(pending[0].After) == n {
+			fs.ready(pending[0])
+			pending = pending[1:]
+		}
+		for _, hit := range pending {
+			hit.After = append(hit.After, text)
+		}
+		if job.match(text) && job.inRange(fs.parser, text) {
+			if job.hits.Add(1) > int64(job.limit) && job.limit > 0 {
+				return false
+			}
+			hit := &SearchHit{Offset: stop, Line: text}
+			if len(before) > 0 {
+				hit.Before = append([]string{}, before...)
+			}
+			pending = append(pending, hit)
+		}
+		if n > 0 {
+			before = append(before, text)
+			if len(before) > n {
+				before = before[1:]
+			}
+		}
+		return ctx.Err() == nil
+	})
+	for _, hit := range pending {
+		fs.ready(hit)
+	}
+	return err
+}
+
+// match checks if line matches query
+func (job *searchJob) match(text string) bool {
+	if job.terms == nil {
+		return job.re.MatchString(text)
+	}
+	words := Tokenize(text)
+	for _, t := range job.terms {
+		if !slices.Contains(words, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRange checks if line time fits query time range
+// Lines without parsed time are accepted
+func (job *searchJob) inRange(parser lineParser, text string) bool {
+	q := job.query
+	if parser == nil || (q.Since.IsZero() && q.Until.IsZero()) {
+		return true
+	}
+	ts, ok := lineTime(parser(text))
+	if !ok {
+		return true
+	}
+	return (q.Since.IsZero() || !ts.Before(q.Since)) && (q.Until.IsZero() || !ts.After(q.Until))
+}
+
+// lineTime returns time field of parsed line
+func lineTime(fields map[string]interface{}) (time.Time, bool) {
+	for _, k := range []string{"time", "ts", "timestamp", "@timestamp"} {
+		if v, ok := fields[k].(string); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return ts, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// searchStart starts search for client
+func (h *Hub) searchStart(query *SearchQuery, client *Client) []byte {
+	if query == nil {
+		query = &SearchQuery{}
+	}
+	if _, ok := h.searches[client][query.ID]; ok {
+		return formatSearchError(query.ID, ErrSearchRunning)
+	}
+	job, err := h.workers.prepareSearch(query)
+	if err != nil {
+		return formatSearchError(query.ID, err)
+	}
+	job.exempt = h.workers.redactExempt(client)
+	event := clientEvent(AuditSearch, client, query.Glob)
+	event.Query = query.Pattern
+	h.audit.Record(event)
+	ctx, cancel := context.WithCancel(context.Background())
+	if h.searches[client] == nil {
+		h.searches[client] = make(map[string]context.CancelFunc)
+	}
+	h.searches[client][query.ID] = cancel
+	go job.run(ctx, func(msg *SearchMessage) bool {
+		data, _ := json.Marshal(msg)
+		reply := &clientReply{client: client, data: data}
+		if msg.Type == "search_done" {
+			reply.done = func() {
+				cancel()
+				delete(h.searches[client], query.ID)
+			}
+		}
+		select {
+		case h.replies <- reply:
+			return true
+		case <-h.done:
+			return false
+		}
+	})
+	return nil
+}
+
+// searchCancel cancels client search
+func (h *Hub) searchCancel(query *SearchQuery, client *Client) []byte {
+	id := ""
+	if query != nil {
+		id = query.ID
+	}
+	cancel, ok := h.searches[client][id]
+	if !ok {
+		return formatSearchError(id, ErrSearchUnknown)
+	}
+	cancel()
+	return nil
+}
+
+// searchStop cancels all client searches
+func (h *Hub) searchStop(client *Client) {
+	for _, cancel := range h.searches[client] {
+		cancel()
+	}
+	delete(h.searches, client)
+}
+
+// formatSearchError packs search error to json
+func formatSearchError(id string, err error) []byte {
+	data, _ := json.Marshal(SearchMessage{Type: "search_done", ID: id, Error: err.Error()})
+	return data
+}
+
+// Search runs search and calls emit for every result message
+func (wt *Service) Search(ctx context.Context, query *SearchQuery, emit func(*SearchMessage) bool) error {
+	req := &searchRequest{query: query, done: make(chan struct{})}
+	select {
+	case wt.hub.search <- req:
+	case <-wt.hub.done:
+		return ErrSearchUnknown
+	}
+	<-req.done
+	if req.err != nil {
+		return req.err
+	}
+	req.job.run(ctx, emit)
+	return nil
+}
+
+// ServeSearch streams search results as newline delimited json
+// Query args: pattern, regexp, term, icase, glob, since, until (RFC3339), context, limit
+func (wt *Service) ServeSearch(w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()
+	query := &SearchQuery{
+		Pattern:    args.Get("pattern"),
+		Regexp:     args.Get("regexp") != "",
+		Term:       args.Get("term") != "",
+		IgnoreCase: args.Get("icase") != "",
+		Glob:       args.Get("glob"),
+	}
+	var err error
+	for _, arg := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if v := args.Get(arg.name); v != "" && err == nil {
+			*arg.dst, err = time.Parse(time.RFC3339, v)
+		}
+	}
+	for _, arg := range []struct {
+		name string
+		dst  *int
+	}{{"context", &query.Context}, {"limit", &query.Limit}} {
+		if v := args.Get(arg.name); v != "" && err == nil {
+			*arg.dst, err = strconv.Atoi(v)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	event := clientEvent(AuditSearch, wt.requestClient(r), query.Glob)
+	event.Query = query.Pattern
+	wt.hub.audit.Record(event)
+	flusher, _ := w.(http.Flusher)
+	// scan may last longer than server WriteTimeout, so deadline is set per message
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	started := false
+	err = wt.Search(r.Context(), query, func(msg *SearchMessage) bool {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := enc.Encode(msg); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,64 @@
+package webtail
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.log"), []byte("a\nreq=42 start\nb\nc\nreq=42 end\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), []byte("req=42\n"), 0o600))
+	f, err := os.Create(filepath.Join(dir, "app.log.1.gz"))
+	require.NoError(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write([]byte("old REQ=42\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	ts, err := NewTailService(logr.Discard(), &Config{Root: dir, SearchWorkers: 1, SearchHits: 10})
+	require.NoError(t, err)
+	require.NoError(t, loadIndex(ts.index, ts.roots[0], time.Now()))
+
+	run := func(ctx context.Context, q *SearchQuery) []SearchMessage {
+		job, err := ts.prepareSearch(q)
+		require.NoError(t, err)
+		var rv []SearchMessage
+		job.run(ctx, func(msg *SearchMessage) bool {
+			rv = append(rv, *msg)
+			return true
+		})
+		return rv
+	}
+
+	got := run(context.Background(), &SearchQuery{ID: "1", Pattern: "req=42", IgnoreCase: true, Glob: "app.*", Context: 1})
+	require.Len(t, got, 3)
+	assert.Equal(t, SearchMessage{Type: "search_result", ID: "1", File: "app.log", Data: []SearchHit{
+		{Offset: 15, Line: "req=42 start", Before: []string{"a"}, After: []string{"b"}},
+		{Offset: 30, Line: "req=42 end", Before: []string{"c"}},
+	}}, got[0])
+	assert.Equal(t, SearchMessage{Type: "search_result", ID: "1", File: "app.log.1.gz", Data: []SearchHit{
+		{Offset: 11, Line: "old REQ=42"},
+	}}, got[1])
+	assert.Equal(t, SearchMessage{Type: "search_done", ID: "1", Files: 2, Hits: 3}, got[2])
+
+	got = run(context.Background(), &SearchQuery{Pattern: `req=\d+ (start|end)`, Regexp: true, Limit: 1})
+	assert.Equal(t, SearchMessage{Type: "search_done", Files: 1, Hits: 1}, got[len(got)-1])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got = run(ctx, &SearchQuery{Pattern: "req"})
+	assert.True(t, got[len(got)-1].Canceled)
+
+	_, err = ts.prepareSearch(&SearchQuery{Pattern: "(", Regexp: true})
+	assert.Error(t, err)
+}
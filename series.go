@@ -0,0 +1,121 @@
+package webtail
+
+// This file holds activity time series of tailed and indexed files
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// tailed files: lines and bytes per minute for 24h
+	seriesStep  = time.Minute
+	seriesSteps = 24 * 60
+	// indexed files: size growth per 15 minutes for 24h
+	growthStep  = 15 * time.Minute
+	growthSteps = 24 * 4
+)
+
+// lineSeries holds tailed lines and bytes per step
+type lineSeries struct {
+	lines rollingCounts
+	bytes rollingCounts
+}
+
+// SeriesMessage holds outgoing activity series of file
+// Series values are ordered from the oldest step, the last one ends at End
+type SeriesMessage struct {
+	Type       string    `json:"type"`
+	Channel    string    `json:"channel"`
+	End        time.Time `json:"end"`
+	Step       int       `json:"step"`            // seconds
+	Lines      []uint64  `json:"lines,omitempty"` // set if file was tailed
+	Bytes      []uint64  `json:"bytes,omitempty"` // set if file was tailed
+	GrowthStep int       `json:"growth_step"`     // seconds
+	Growth     []uint64  `json:"growth,omitempty"`
+}
+
+// GrowthListMessage holds outgoing growth series of indexed files
+// Files without growth within 24h are skipped
+type GrowthListMessage struct {
+	Type string              `json:"type"`
+	End  time.Time           `json:"end"`
+	Step int                 `json:"step"` // seconds
+	Data map[string][]uint64 `json:"data"`
+}
+
+// newLineSeries creates tailed lines series
+func newLineSeries() *lineSeries {
+	return &lineSeries{
+		lines: newRollingCounts(seriesSteps, seriesStep),
+		bytes: newRollingCounts(seriesSteps, seriesStep),
+	}
+}
+
+// SeriesAdd counts tailed line in series of index item
+func (ts *TailService) SeriesAdd(msg *TailMessage) {
+	attr, ok := ts.index[msg.Channel]
+	if !ok || msg.Type != "log" {
+		return
+	}
+	if attr.series == nil {
+		attr.series = newLineSeries()
+	}
+	now := time.Now()
+	attr.series.lines.add(now)
+	attr.series.bytes.addN(now, uint64(len(msg.Data)+len(newline)))
+}
+
+// addGrowth counts file size increase since prev
+func addGrowth(prev, attr *IndexItemAttr, now time.Time) {
+	attr.growth = prev.growth
+	delta := attr.Size - prev.Size
+	if delta <= 0 {
+		return
+	}
+	if attr.growth == nil {
+		counts := newRollingCounts(growthSteps, growthStep)
+		attr.growth = &counts
+	}
+	attr.growth.addN(now, uint64(delta))
+}
+
+// stepEnd returns end of the current step
+func stepEnd(now time.Time, step time.Duration) time.Time {
+	return now.Truncate(step).Add(step)
+}
+
+// Series returns activity series message of channel or growth of all indexed files matched by query if channel is empty
+func (ts *TailService) Series(channel string, query *IndexQuery) []byte {
+	now := time.Now()
+	if channel == "" {
+		msg := GrowthListMessage{Type: "growth_list", End: stepEnd(now, growthStep), Step: int(growthStep / time.Second), Data: map[string][]uint64{}}
+		for name, attr := range ts.index {
+			if attr.growth != nil && query.Match(name) && attr.growth.count(growthStep*growthSteps, now) > 0 {
+				msg.Data[name] = attr.growth.values(now)
+			}
+		}
+		data, _ := json.Marshal(msg)
+		return data
+	}
+	attr, ok := ts.index[channel]
+	if !ok {
+		return formatTailMessage(channel, "series", MsgUnknownChannel, false)
+	}
+	msg := SeriesMessage{
+		Type:       "series",
+		Channel:    channel,
+		End:        stepEnd(now, seriesStep),
+		Step:       int(seriesStep / time.Second),
+		GrowthStep: int(growthStep / time.Second),
+	}
+	if attr.series != nil {
+		msg.Lines = attr.series.lines.values(now)
+		msg.Bytes = attr.series.bytes.values(now)
+	}
+	if attr.growth != nil {
+		msg.Growth = attr.growth.values(now)
+	}
+	data, _ := json.Marshal(msg)
+	return data
+}
@@ -0,0 +1,54 @@
+package webtail
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollingCounts(t *testing.T) {
+	c := newRollingCounts(4, time.Minute)
+	now := time.Unix(1700000000, 0).Truncate(time.Minute)
+	c.addN(now.Add(-2*time.Minute), 5)
+	c.add(now)
+	c.add(now)
+	assert.Equal(t, []uint64{0, 5, 0, 2}, c.values(now))
+	assert.Equal(t, uint64(2), c.count(time.Minute, now))
+	assert.Equal(t, []uint64{2, 0, 0, 0}, c.values(now.Add(3*time.Minute)))
+}
+
+func TestSeries(t *testing.T) {
+	ts := &TailService{Config: &Config{}, rules: &Rules{}, index: IndexItemAttrStore{}, workers: map[string]*TailAttr{}}
+	ts.treeRebuild()
+	ts.IndexUpdate(&IndexItemEvent{Name: "a.log", IndexItemAttr: IndexItemAttr{Size: 10}})
+	ts.IndexUpdate(&IndexItemEvent{Name: "a.log", IndexItemAttr: IndexItemAttr{Size: 25}})
+	ts.IndexUpdate(&IndexItemEvent{Name: "b.log", RenamedFrom: "a.log", IndexItemAttr: IndexItemAttr{Size: 30}})
+	ts.IndexUpdate(&IndexItemEvent{Name: "c.log", IndexItemAttr: IndexItemAttr{Size: 30}})
+
+	list := GrowthListMessage{}
+	require.NoError(t, json.Unmarshal(ts.Series("", nil), &list))
+	assert.Equal(t, []string{"b.log"}, keys(list.Data), "renamed file keeps growth, new file has none")
+	values := list.Data["b.log"]
+	assert.Len(t, values, growthSteps)
+	assert.Equal(t, uint64(20), values[len(values)-1])
+
+	ts.SeriesAdd(&TailMessage{Type: "log", Channel: "b.log", Data: "line"})
+	ts.IndexUpdate(&IndexItemEvent{Name: "d.log", RenamedFrom: "b.log", IndexItemAttr: IndexItemAttr{Size: 35}})
+	assert.Equal(t, formatTailMessage("b.log", "series", MsgUnknownChannel, false), ts.Series("b.log", nil))
+	msg := SeriesMessage{}
+	require.NoError(t, json.Unmarshal(ts.Series("d.log", nil), &msg))
+	assert.Equal(t, uint64(1), msg.Lines[seriesSteps-1], "series is kept by index item")
+	assert.Equal(t, uint64(5), msg.Bytes[seriesSteps-1])
+	assert.Equal(t, 60, msg.Step)
+}
+
+func keys(m map[string][]uint64) []string {
+	rv := []string{}
+	for k := range m {
+		rv = append(rv, k)
+	}
+	return rv
+}
@@ -0,0 +1,61 @@
+package webtail
+
+// This file holds cached index snapshot methods
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etagNonce differs between process starts because index version starts from 0
+var etagNonce = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// IndexSnapshot holds index state shared with concurrent readers
+// Snapshot is immutable after creation
+type IndexSnapshot struct {
+	Version uint64           `json:"version"`
+	Items   []IndexItemEvent `json:"items"`
+
+	// json encoded snapshot
+	data []byte
+}
+
+// IndexSnapshot returns index snapshot
+// Snapshot is rebuilt if index changed and cached one is older than maxAge
+func (ts *TailService) IndexSnapshot(maxAge time.Duration) *IndexSnapshot {
+	snap := ts.snapshot.Load()
+	if snap != nil && (snap.Version == ts.indexVersion || time.Since(ts.snapshotTime) < maxAge) {
+		return snap
+	}
+	keys := ts.IndexKeys()
+	snap = &IndexSnapshot{Version: ts.indexVersion, Items: make([]IndexItemEvent, len(keys))}
+	for i, k := range keys {
+		snap.Items[i] = IndexItemEvent{Name: k, Root: ts.ChannelRoot(k), IndexItemAttr: *ts.index[k]}
+	}
+	snap.data, _ = json.Marshal(snap)
+	ts.snapshot.Store(snap)
+	ts.snapshotTime = time.Now()
+	return snap
+}
+
+// ServeIndex serves index snapshot as json
+// Snapshot version with process nonce is used as ETag
+func (wt *Service) ServeIndex(w http.ResponseWriter, r *http.Request) {
+	snap := wt.hub.workers.snapshot.Load()
+	if snap == nil {
+		http.Error(w, "index is not ready", http.StatusServiceUnavailable)
+		return
+	}
+	etag := `"` + etagNonce + "-" + strconv.FormatUint(snap.Version, 10) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match == "*" || strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(snap.data)
+}
@@ -0,0 +1,107 @@
+package webtail
+
+// This file holds file content detection
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// sniffSize is a size of file head used for content detection
+const sniffSize = 512
+
+// Compression formats
+const (
+	CompressGzip  = "gz"
+	CompressZstd  = "zst"
+	CompressBzip2 = "bz2"
+	CompressXz    = "xz"
+)
+
+// Detected encodings
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	Encoding8bit    = "8bit"
+)
+
+// magics holds compressed file signatures
+var magics = []struct {
+	format string
+	magic  []byte
+}{
+	{CompressGzip, []byte{0x1f, 0x8b}},
+	{CompressZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressBzip2, []byte("BZh")},
+	{CompressXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// sniffFile fills attrs detected by file content
+func sniffFile(f *os.File, attr *IndexItemAttr) {
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && n == 0 {
+		return
+	}
+	head = head[:n]
+	if attr.Compressed = compression(head); attr.Compressed != "" {
+		attr.Binary = true
+		return
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xfe}):
+		// lines are split by newline byte, so UTF-16 files can't be tailed
+		attr.Encoding, attr.Unreadable = EncodingUTF16LE, true
+		return
+	case bytes.HasPrefix(head, []byte{0xfe, 0xff}):
+		attr.Encoding, attr.Unreadable = EncodingUTF16BE, true
+		return
+	case bytes.IndexByte(head, 0) >= 0:
+		attr.Binary = true
+		return
+	}
+	if n == sniffSize {
+		// last rune may be truncated
+		for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if utf8.Valid(head) {
+		attr.Encoding = EncodingUTF8
+	} else {
+		attr.Encoding = Encoding8bit
+	}
+	attr.Parser = sniffParser(head)
+}
+
+// compression returns compression format by file signature
+func compression(head []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return ""
+}
+
+// sniffParser returns parser which fits the first line
+func sniffParser(head []byte) string {
+	line, _, ok := bytes.Cut(head, []byte(newline))
+	if !ok {
+		// line is not complete
+		return ""
+	}
+	if bytes.HasPrefix(line, []byte("{")) && parseJSON(string(line)) != nil {
+		return "json"
+	}
+	if combinedLine.Match(line) {
+		return "combined"
+	}
+	if fields := parseLogfmt(string(line)); len(fields) > 1 {
+		return "logfmt"
+	}
+	return ""
+}
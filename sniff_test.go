@@ -0,0 +1,78 @@
+package webtail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffFile(t *testing.T) {
+	tests := []struct {
+		data string
+		want IndexItemAttr
+	}{
+		{"Some log data\n", IndexItemAttr{Encoding: EncodingUTF8}},
+		{`{"level":"info"}` + "\n", IndexItemAttr{Encoding: EncodingUTF8, Parser: "json"}},
+		{"level=info msg=started\n", IndexItemAttr{Encoding: EncodingUTF8, Parser: "logfmt"}},
+		{"\xe0\xf2\xee\n", IndexItemAttr{Encoding: Encoding8bit}},
+		{"\x1f\x8b\x08\x00", IndexItemAttr{Compressed: CompressGzip, Binary: true}},
+		{"\x00\x01\x02", IndexItemAttr{Binary: true}},
+		{"\xff\xfea\x00\n\x00", IndexItemAttr{Encoding: EncodingUTF16LE, Unreadable: true}},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		file := filepath.Join(dir, "file.log")
+		require.NoError(t, os.WriteFile(file, []byte(tt.data), 0o600))
+		fh, err := os.Open(file)
+		require.NoError(t, err)
+		attr := IndexItemAttr{}
+		sniffFile(fh, &attr)
+		fh.Close()
+		assert.Equal(t, tt.want, attr, i)
+	}
+}
+
+func TestGrowthRate(t *testing.T) {
+	now := time.Now()
+	prev := &IndexItemAttr{Size: 100, seen: now}
+	cur := &IndexItemAttr{Size: 400, seen: now.Add(30 * time.Second)}
+	assert.Equal(t, 600.0, growthRate(prev, cur))
+	prev.Rate = 200
+	assert.Equal(t, 400.0, growthRate(prev, cur))
+	cur.Size = 10
+	assert.Equal(t, 0.0, growthRate(prev, cur))
+}
+
+func TestRateDecay(t *testing.T) {
+	now := time.Now()
+	ts := &TailService{index: IndexItemAttrStore{
+		"busy.log": {Rate: 10, seen: now.Add(-time.Minute)},
+		"idle.log": {Rate: 10, seen: now.Add(-rateIdle - time.Second)},
+	}}
+	ts.RateDecay(now)
+	assert.Equal(t, 10.0, ts.index["busy.log"].Rate)
+	assert.Equal(t, 0.0, ts.index["idle.log"].Rate)
+	assert.Equal(t, uint64(1), ts.indexVersion)
+}
+
+func TestFileAttrKnown(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.log")
+	require.NoError(t, os.WriteFile(file, []byte(`{"a":1}`+"\n"), 0o600))
+	fi, err := os.Stat(file)
+	require.NoError(t, err)
+	known := fileAttr(file, fi, nil)
+	assert.Equal(t, "json", known.Parser)
+
+	require.NoError(t, os.WriteFile(file, []byte("plain line\n"), 0o600))
+	fi, err = os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, "json", fileAttr(file, fi, known).Parser, "grown file is not detected again")
+	require.NoError(t, os.Truncate(file, 5))
+	fi, err = os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, "", fileAttr(file, fi, known).Parser, "truncated file is detected again")
+}
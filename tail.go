@@ -3,14 +3,19 @@ package webtail
 // This file holds directory file tail methods
 
 import (
+	"fmt"
 	"io"
+	"math"
 	"os"
-	"path"
-	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/nxadm/tail"
+	"golang.org/x/text/encoding"
 )
 
 // TailAttr holds tail worker attributes
@@ -18,11 +23,40 @@ type TailAttr struct {
 	// Store for last Config.Lines lines
 	Buffer [][]byte
 
+	// Buffer size in bytes
+	BufferSize int64
+
+	// Buffer size in lines
+	Lines int
+
+	// Sequence number of the last appended line
+	Seq uint64
+
+	// Line rate statistics
+	Rate lineRate
+
+	// Line template miner, nil if disabled
+	Miner *templateMiner
+
+	// Field counters, nil if disabled
+	Counters fieldCounters
+
+	// The last line for repeats suppression
+	dedup *dedupState
+
+	// Originals of redacted buffer lines by sequence number, kept if rules have exemptions
+	Raw map[uint64][]byte
+
+	// Lines which ended before this offset were read on worker start
+	from int64
+	// Offset of the last line, used to detect truncation
+	last int64
+
+	// Settings used on worker start
+	Settings TailSettings
+
 	// Quit worker process
 	Quit chan struct{}
-
-	// Skip 1st line when read file not from start
-	IsHeadTrimmed bool
 }
 
 // TailService holds Worker hub operations
@@ -31,36 +65,145 @@ type TailService struct {
 	Config  *Config
 	workers map[string]*TailAttr
 	index   IndexItemAttrStore
+	tree    map[string]*dirNode
+	metrics *Metrics
+	rules   *Rules
+	roots   []LogRoot
+
+	// All buffers size in bytes
+	bufferSize int64
+
+	// Trace state, set from Config and changed by clients
+	trace bool
+
+	// Index change counter
+	indexVersion uint64
+	// Search budget
+	searchLimiter *searchLimiter
+	// Search term index, nil if disabled
+	terms *TermIndex
+
+	// Index snapshot for concurrent readers
+	snapshot     atomic.Pointer[IndexSnapshot]
+	snapshotTime time.Time
+	// Health rules for concurrent readers
+	healthRules atomic.Pointer[[]HealthRule]
 }
 
+// BufferStats holds buffers memory usage
+type BufferStats struct {
+	Total    int64            `json:"total"`
+	Channels map[string]int64 `json:"channels,omitempty"`
+}
+
+// Metric names
+const (
+	metricBufferBytes      = "webtail_buffer_bytes"
+	metricBufferTotalBytes = "webtail_buffer_total_bytes"
+	metricBufferEvicted    = "webtail_buffer_evicted_lines_total"
+)
+
 // tailWorker holds tailer run arguments
 type tailWorker struct {
 	out     chan *TailMessage
 	quit    chan struct{}
 	log     logr.Logger
-	tf      *tail.Tail
+	tf      lineSource
 	channel string
+	decoder *encoding.Decoder
+	parser  lineParser
+	// multiline record start, nil if disabled
+	multiline *regexp.Regexp
+	// skip the 1st line when file is read not from start
+	trimmed bool
+	// max line part size, lines are split after redaction
+	split     int
+	redactors []*redactor
+	metrics   *Metrics
+}
+
+// lineSource is a stream of file lines
+type lineSource interface {
+	Lines() <-chan *tail.Line
+	Err() error
+	Stop() error
+}
+
+// followTail is a lineSource of followed file
+type followTail struct {
+	*tail.Tail
+}
+
+// Lines returns lines channel
+func (ft followTail) Lines() <-chan *tail.Line {
+	return ft.Tail.Lines
 }
 
+// multilineWait is a time to wait for multiline record continuation
+const multilineWait = 500 * time.Millisecond
+
 // NewTailService creates tailer service
 func NewTailService(logger logr.Logger, cfg *Config) (*TailService, error) {
-	_, err := os.Stat(cfg.Root)
-	if err != nil {
-		return nil, err
-	}
-	aPath, err := filepath.Abs(cfg.Root)
+	rules, roots, err := prepareConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
-	if aPath != cfg.Root {
-		cfg.Root = aPath
+	metrics := NewMetrics()
+	metrics.Describe(metricBufferBytes, MetricGauge, "Channel buffer size in bytes")
+	metrics.Describe(metricBufferTotalBytes, MetricGauge, "All channel buffers size in bytes")
+	metrics.Describe(metricBufferEvicted, MetricCounter, "Lines evicted from buffers by size limits")
+	metrics.Describe(metricLineRate, MetricGauge, "Lines per anomaly interval")
+	metrics.Describe(metricLineBaseline, MetricGauge, "Expected lines per anomaly interval")
+	metrics.Describe(metricAnomaly, MetricGauge, "Current line rate anomaly of file")
+	metrics.Describe(metricAnomalies, MetricCounter, "Line rate anomalies detected")
+	metrics.Describe(metricRedactions, MetricCounter, "Secrets redacted in lines")
+	var terms *TermIndex
+	if cfg.StateDir != "" {
+		terms, err = NewTermIndex(logger, cfg.StateDir, cfg.TermIndexRate, metrics)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return &TailService{
+	ts := &TailService{
 		Config:  cfg,
+		trace:   cfg.Trace,
 		log:     logger,
 		workers: make(map[string]*TailAttr),
 		index:   make(IndexItemAttrStore),
-	}, nil
+		metrics: metrics,
+		rules:   rules,
+		roots:   roots,
+
+		searchLimiter: newSearchLimiter(cfg.SearchWorkers, cfg.SearchRate),
+		terms:         terms,
+	}
+	ts.healthRules.Store(&rules.Health)
+	return ts, nil
+}
+
+// prepareConfig checks config, loads roots and rules file
+func prepareConfig(cfg *Config) (*Rules, []LogRoot, error) {
+	roots, err := parseRoots(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules, err := LoadRules(cfg.Rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = rules.checkRoots(roots); err != nil {
+		return nil, nil, fmt.Errorf("rules check: %w", err)
+	}
+	if err = checkRotation(cfg.Rotation); err != nil {
+		return nil, nil, err
+	}
+	if err = checkWebhooks(cfg.AlertWebhooks); err != nil {
+		return nil, nil, err
+	}
+	if err = checkProxies(cfg.TrustedProxies); err != nil {
+		return nil, nil, err
+	}
+	return rules, roots, nil
 }
 
 // WorkerExists checks if worker already registered
@@ -81,23 +224,30 @@ func (ts *TailService) ChannelExists(channel string) bool {
 // SetTrace turns on/off logging of incoming workers messages
 func (ts *TailService) SetTrace(mode string) {
 	if mode == "on" {
-		ts.Config.Trace = true
+		ts.trace = true
 	} else if mode == "off" {
-		ts.Config.Trace = false
+		ts.trace = false
 	}
-	ts.log.Info("Tracing", "trace", ts.Config.Trace)
+	ts.log.Info("Tracing", "trace", ts.trace)
 }
 
 // TraceEnabled returns trace state
 func (ts *TailService) TraceEnabled() bool {
-	return ts.Config.Trace
+	return ts.trace
 }
 
 // WorkerStop stops worker (tailer or indexer)
 func (ts *TailService) WorkerStop(channel string) {
-	w := ts.workers[channel]
-	w.Quit <- struct{}{}
+	w, ok := ts.workers[channel]
+	if !ok {
+		// stopped already
+		return
+	}
+	close(w.Quit)
+	ts.setBufferSize(channel, w, 0)
 	delete(ts.workers, channel)
+	ts.metrics.Delete(metricBufferBytes, "channel", channel)
+	ts.deleteRateMetrics(channel, w)
 }
 
 // TailerBuffer returns worker buffer
@@ -105,60 +255,194 @@ func (ts *TailService) TailerBuffer(channel string) [][]byte {
 	return ts.workers[channel].Buffer
 }
 
-// TailerAppend adds a line into worker buffer
-func (ts *TailService) TailerAppend(channel string, data []byte) bool {
-	if ts.workers[channel].IsHeadTrimmed {
-		// Skip first trimmed (partial) line
-		ts.workers[channel].IsHeadTrimmed = false
+// TailerBufferSeq returns worker buffer and sequence number of its first line
+func (ts *TailService) TailerBufferSeq(channel string) ([][]byte, uint64) {
+	w := ts.workers[channel]
+	return w.Buffer, w.Seq - uint64(len(w.Buffer)) + 1
+}
+
+// TailerSeq returns sequence number of the last appended line
+func (ts *TailService) TailerSeq(channel string) uint64 {
+	return ts.workers[channel].Seq
+}
+
+// TailerLive reports if line was appended to file after worker start
+// Lines read on start are not counted as arriving now
+func (ts *TailService) TailerLive(msg *TailMessage) bool {
+	w, ok := ts.workers[msg.Channel]
+	if !ok || msg.Type != "log" {
 		return false
 	}
-	buf := ts.workers[channel].Buffer
-	if len(buf) == ts.Config.Lines {
+	if msg.Offset < w.last {
+		// file truncated
+		w.from = 0
+	}
+	w.last = msg.Offset
+	return msg.Offset > w.from
+}
+
+// TailerAppend adds a line into worker buffer
+// raw is the original of redacted line kept for exempt clients, nil if not needed
+// Both are counted in buffer size
+func (ts *TailService) TailerAppend(channel string, data, raw []byte) {
+	w := ts.workers[channel]
+	w.Buffer = append(w.Buffer, data)
+	w.Seq++
+	w.Rate.count++
+	size := int64(len(data))
+	if raw != nil {
+		if w.Raw == nil {
+			w.Raw = make(map[uint64][]byte)
+		}
+		w.Raw[w.Seq] = raw
+		size += int64(len(raw))
+	}
+	ts.setBufferSize(channel, w, w.BufferSize+size)
+	limit := ts.Config.BufferBytes
+	for len(w.Buffer) > 0 && (len(w.Buffer) > w.Lines || (limit > 0 && w.BufferSize > limit)) {
 		// drop oldest line if buffer is full
-		buf = buf[1:]
+		ts.tailerEvict(channel, w)
+	}
+	if limit = ts.Config.BufferTotalBytes; limit > 0 {
+		for ts.bufferSize > limit {
+			// drop oldest line from the largest buffer
+			ch, largest := ts.largestBuffer()
+			if largest == nil {
+				break
+			}
+			ts.tailerEvict(ch, largest)
+		}
+	}
+}
+
+// BufferStats returns buffers memory usage
+func (ts *TailService) BufferStats() *BufferStats {
+	rv := &BufferStats{Total: ts.bufferSize, Channels: make(map[string]int64)}
+	for k, w := range ts.workers {
+		if k != "" {
+			rv.Channels[k] = w.BufferSize
+		}
+	}
+	return rv
+}
+
+// tailerEvict drops the oldest line of worker buffer
+func (ts *TailService) tailerEvict(channel string, w *TailAttr) {
+	seq := w.Seq - uint64(len(w.Buffer)) + 1
+	size := int64(len(w.Buffer[0]) + len(w.Raw[seq]))
+	delete(w.Raw, seq)
+	w.Buffer[0] = nil
+	w.Buffer = w.Buffer[1:]
+	ts.setBufferSize(channel, w, w.BufferSize-size)
+	if len(w.Buffer) < w.Lines {
+		// count only lines evicted by size limits
+		ts.metrics.Add(metricBufferEvicted, 1)
+	}
+}
+
+// largestBuffer returns worker with the largest buffer
+func (ts *TailService) largestBuffer() (string, *TailAttr) {
+	var (
+		channel string
+		rv      *TailAttr
+	)
+	for k, w := range ts.workers {
+		if len(w.Buffer) > 0 && (rv == nil || w.BufferSize > rv.BufferSize) {
+			channel, rv = k, w
+		}
 	}
-	buf = append(buf, data)
-	ts.workers[channel].Buffer = buf
-	return true
+	return channel, rv
+}
+
+// setBufferSize updates worker and total buffer size
+func (ts *TailService) setBufferSize(channel string, w *TailAttr, size int64) {
+	ts.bufferSize += size - w.BufferSize
+	w.BufferSize = size
+	ts.metrics.Set(metricBufferBytes, float64(size), "channel", channel)
+	ts.metrics.Set(metricBufferTotalBytes, float64(ts.bufferSize))
 }
 
 // TailerRun creates and runs tail worker
 func (ts *TailService) TailerRun(channel string, out chan *TailMessage, readyChan chan struct{}, wg *sync.WaitGroup) error {
-	cfg := ts.Config
+	set := ts.TailSettings(channel)
+	// MaxLineSize is not set, lines are split by worker after redaction
 	config := tail.Config{
-		Follow:      true,
-		ReOpen:      true,
-		MustExist:   true,
-		MaxLineSize: cfg.MaxLineSize,
-		Poll:        cfg.Poll,
+		Follow:    true,
+		ReOpen:    true,
+		MustExist: true,
+		Poll:      set.Poll,
 	}
-	filename := path.Join(cfg.Root, channel)
+	filename := ts.channelFile(channel)
 	headTrimmed := false
-
-	if cfg.Bytes != 0 {
+	compressed, err := isCompressed(filename)
+	if err != nil {
+		return err
+	}
+	// compressed file can't grow, all its lines are old
+	var from int64 = math.MaxInt64
+	if !compressed {
 		fi, err := os.Stat(filename)
 		if err != nil {
 			return err
 		}
 		// get the file size
 		size := fi.Size()
-		if size > cfg.Bytes {
-			config.Location = &tail.SeekInfo{Offset: -cfg.Bytes, Whence: io.SeekEnd}
+		from = size
+		if set.Bytes != 0 && size > set.Bytes {
+			config.Location = &tail.SeekInfo{Offset: -set.Bytes, Whence: io.SeekEnd}
 			headTrimmed = true
 		}
 	}
-	t, err := tail.TailFile(filename, config)
+	if attr, ok := ts.index[channel]; ok && set.Encoding == "" && (attr.Encoding == EncodingUTF16LE || attr.Encoding == EncodingUTF16BE) {
+		return fmt.Errorf("%w %q", ErrUnsupportedEncoding, attr.Encoding)
+	}
+	decoder, err := lineDecoder(set.Encoding)
 	if err != nil {
 		return err
 	}
+	var src lineSource
+	if compressed {
+		// compressed file can't grow, read it once
+		src = newStaticTail(filename, set)
+	} else {
+		t, err := tail.TailFile(filename, config)
+		if err != nil {
+			return err
+		}
+		src = followTail{t}
+	}
 	quit := make(chan struct{})
-	ts.workers[channel] = &TailAttr{Buffer: [][]byte{}, Quit: quit, IsHeadTrimmed: headTrimmed}
+	var miner *templateMiner
+	if set.Templates {
+		miner = newTemplateMiner()
+	}
+	var counters fieldCounters
+	if len(set.Aggregate) > 0 {
+		counters = newFieldCounters(set.Aggregate)
+	}
+	ts.workers[channel] = &TailAttr{
+		Buffer:   [][]byte{},
+		Quit:     quit,
+		Lines:    set.Lines,
+		Settings: set,
+		Rate:     lineRate{start: time.Now()},
+		Miner:    miner,
+		Counters: counters,
+		from:     from,
+	}
 	go tailWorker{
-		tf:      t,
-		channel: channel,
-		out:     out,
-		quit:    quit,
-		log:     ts.log,
+		tf:        src,
+		channel:   channel,
+		out:       out,
+		quit:      quit,
+		log:       ts.log,
+		decoder:   decoder,
+		parser:    parsers[set.Parser],
+		multiline: set.Multiline,
+		trimmed:   headTrimmed,
+		split:     set.Split,
+		redactors: newRedactors(set.Redact),
+		metrics:   ts.metrics,
 	}.run(readyChan, wg)
 	return nil
 }
@@ -173,16 +457,49 @@ func (tw tailWorker) run(readyChan chan struct{}, wg *sync.WaitGroup) {
 	log := tw.log.WithValues("channel", tw.channel)
 	log.Info("Tailer started")
 	readyChan <- struct{}{}
+	var (
+		record  *TailMessage // multiline record being collected
+		timeout <-chan time.Time
+	)
 	for {
 		select {
-		case line, ok := <-tw.tf.Lines:
+		case line, ok := <-tw.tf.Lines():
+			if !ok && tw.tf.Err() == nil {
+				// static file is read
+				tw.send(record)
+				log.Info("Tailer reached end of file")
+				<-tw.quit
+				return
+			}
 			if !ok {
 				log.Error(tw.tf.Err(), "Tailer channel is unavailable")
-				tw.out <- &TailMessage{Channel: tw.channel, Data: tw.tf.Err().Error(), Type: "error"}
+				tw.send(record)
+				tw.send(&TailMessage{Channel: tw.channel, Data: tw.tf.Err().Error(), Type: "error"})
 				<-tw.quit
 				return
 			}
-			tw.out <- &TailMessage{Channel: tw.channel, Data: line.Text, Type: "log"}
+			if tw.trimmed {
+				// partial line
+				tw.trimmed = false
+				continue
+			}
+			msg := &TailMessage{Channel: tw.channel, Data: decodeLine(tw.decoder, line.Text), Offset: line.SeekInfo.Offset, Type: "log"}
+			if tw.multiline == nil {
+				tw.send(msg)
+				continue
+			}
+			if record != nil && !tw.multiline.MatchString(msg.Data) {
+				// continuation of multiline record
+				record.Data += newline + msg.Data
+				record.Offset = msg.Offset
+			} else {
+				tw.send(record)
+				record = msg
+			}
+			timeout = time.After(multilineWait)
+		case <-timeout:
+			tw.send(record)
+			record, timeout = nil, nil
 		case <-tw.quit:
 			err := tw.tf.Stop() // Cleanup()
 			if err != nil {
@@ -194,3 +511,73 @@ func (tw tailWorker) run(readyChan chan struct{}, wg *sync.WaitGroup) {
 		}
 	}
 }
+
+// send redacts line, splits it and sends parts to hub
+// Line is split after redaction, so secrets on part boundaries are found
+func (tw tailWorker) send(msg *TailMessage) {
+	if msg == nil {
+		return
+	}
+	if msg.Type != "log" {
+		tw.emit(msg)
+		return
+	}
+	if len(tw.redactors) > 0 {
+		if data, ok := redactLine(tw.redactors, msg.Data, tw.metrics); ok {
+			msg.raw, msg.Data = msg.Data, data
+		}
+	}
+	if tw.multiline != nil {
+		// record is sent as one message
+		msg.Data = splitRecord(msg.Data, tw.split)
+		if msg.raw != "" {
+			msg.raw = splitRecord(msg.raw, tw.split)
+		}
+		tw.emit(msg)
+		return
+	}
+	parts := splitLine(msg.Data, tw.split)
+	if len(parts) == 1 {
+		tw.emit(msg)
+		return
+	}
+	var raws []string
+	if msg.raw != "" {
+		raws = splitLine(msg.raw, tw.split)
+	}
+	for i, part := range parts {
+		m := *msg
+		m.Data, m.raw = part, ""
+		if i < len(raws) {
+			m.raw = raws[i]
+		}
+		if i == len(parts)-1 && len(raws) > len(parts) {
+			m.raw = strings.Join(raws[i:], "")
+		}
+		tw.emit(&m)
+	}
+}
+
+// splitRecord splits long lines of multiline record
+func splitRecord(text string, split int) string {
+	if split <= 0 || len(text) <= split {
+		return text
+	}
+	lines := strings.Split(text, newline)
+	for i, line := range lines {
+		lines[i] = strings.Join(splitLine(line, split), newline)
+	}
+	return strings.Join(lines, newline)
+}
+
+// emit parses line and sends it to hub
+func (tw tailWorker) emit(msg *TailMessage) {
+	if tw.parser != nil && msg.Type == "log" {
+		msg.Fields = tw.parser(msg.Data)
+	}
+	select {
+	case tw.out <- msg:
+	case <-tw.quit:
+		// worker is stopping, hub does not wait for lines
+	}
+}
@@ -0,0 +1,120 @@
+package webtail
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/nxadm/tail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailerAppendBudget(t *testing.T) {
+	cfg := &Config{Root: "testdata", Lines: 10, BufferBytes: 9, BufferTotalBytes: 12}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+	ts.workers["a"] = &TailAttr{Lines: cfg.Lines}
+	ts.workers["b"] = &TailAttr{Lines: cfg.Lines}
+
+	for _, line := range []string{"1234", "5678", "90"} {
+		ts.TailerAppend("a", []byte(line), nil)
+	}
+	assert.Equal(t, [][]byte{[]byte("5678"), []byte("90")}, ts.TailerBuffer("a"), "Channel budget applied")
+	assert.Equal(t, int64(6), ts.workers["a"].BufferSize)
+
+	ts.TailerAppend("b", []byte("abcdefgh"), nil)
+	ts.TailerAppend("b", []byte("ij"), nil)
+	assert.Equal(t, [][]byte{[]byte("ij")}, ts.TailerBuffer("b"), "Largest buffer evicted first")
+	assert.Equal(t, [][]byte{[]byte("5678"), []byte("90")}, ts.TailerBuffer("a"))
+	assert.Equal(t, &BufferStats{Total: 8, Channels: map[string]int64{"a": 6, "b": 2}}, ts.BufferStats())
+}
+
+func TestTailerLive(t *testing.T) {
+	ts, err := NewTailService(logr.Discard(), &Config{Root: "testdata"})
+	require.NoError(t, err)
+	ts.workers["a"] = &TailAttr{from: 20}
+	live := func(offset int64) bool {
+		return ts.TailerLive(&TailMessage{Channel: "a", Type: "log", Offset: offset})
+	}
+	assert.False(t, live(10), "line read on start")
+	assert.False(t, live(20))
+	assert.True(t, live(30), "line appended after start")
+	assert.True(t, live(5), "file truncated")
+	assert.False(t, ts.TailerLive(&TailMessage{Channel: "b", Type: "log", Offset: 40}), "unknown channel")
+}
+
+// linesSource is a lineSource of fixed lines
+type linesSource chan *tail.Line
+
+func (s linesSource) Lines() <-chan *tail.Line { return s }
+func (s linesSource) Err() error               { return nil }
+func (s linesSource) Stop() error              { return nil }
+
+func TestTailWorkerTrimmed(t *testing.T) {
+	src := make(linesSource, 4)
+	for _, line := range []string{"tial line", "ERROR first", "  at trace", "INFO second"} {
+		src <- &tail.Line{Text: line}
+	}
+	close(src)
+	out := make(chan *TailMessage, 4)
+	quit := make(chan struct{})
+	ready := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	go tailWorker{
+		tf:        src,
+		channel:   "a",
+		out:       out,
+		quit:      quit,
+		log:       logr.Discard(),
+		multiline: regexp.MustCompile(`^\S`),
+		trimmed:   true,
+	}.run(ready, &wg)
+	got := []string{(<-out).Data, (<-out).Data}
+	close(quit)
+	assert.Equal(t, []string{"ERROR first\n  at trace", "INFO second"}, got, "only partial line is dropped")
+}
+
+func TestTailWorkerSplitRedacted(t *testing.T) {
+	src := make(linesSource, 1)
+	src <- &tail.Line{Text: "token=abcdefghij end", SeekInfo: tail.SeekInfo{Offset: 21}}
+	close(src)
+	out := make(chan *TailMessage, 3)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	go tailWorker{
+		tf:        src,
+		channel:   "a",
+		out:       out,
+		quit:      quit,
+		log:       logr.Discard(),
+		split:     8,
+		redactors: newRedactors([]RedactRule{{Pattern: `token=\w+`, Replace: "token=[REDACTED]"}}),
+		metrics:   NewMetrics(),
+	}.run(make(chan struct{}, 1), &wg)
+	var data, raw []string
+	for range 3 {
+		msg := <-out
+		data, raw = append(data, msg.Data), append(raw, msg.raw)
+		assert.Equal(t, int64(21), msg.Offset)
+	}
+	close(quit)
+	assert.Equal(t, []string{"token=[R", "EDACTED]", " end"}, data, "secret crossing split is redacted")
+	assert.Equal(t, []string{"token=ab", "cdefghij", " end"}, raw)
+}
+
+func TestLineDecoderUTF16(t *testing.T) {
+	_, err := lineDecoder(EncodingUTF16LE)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+	dec, err := lineDecoder("windows-1251")
+	require.NoError(t, err)
+	assert.NotNil(t, dec)
+
+	ts, err := NewTailService(logr.Discard(), &Config{Root: "testdata"})
+	require.NoError(t, err)
+	ts.index["file.log"] = &IndexItemAttr{Encoding: EncodingUTF16LE, Unreadable: true}
+	var wg sync.WaitGroup
+	err = ts.TailerRun("file.log", nil, nil, &wg)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding, "detected UTF-16 is reported")
+}
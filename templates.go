@@ -0,0 +1,230 @@
+package webtail
+
+// This file holds log template miner (Drain-like clustering of channel lines)
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// min share of equal tokens to join line to template
+	templateSimilarity = 0.5
+	// max templates per channel, least recently seen one is dropped
+	maxTemplates = 1000
+	// max tokens compared, the rest of line is a wildcard
+	maxTemplateTokens = 64
+	// count buckets, one per minute
+	templateBuckets = 60
+	// template wildcard
+	templateAny = "<*>"
+)
+
+// Templates query defaults and limits
+const (
+	templatesWindow    = 300
+	templatesLimit     = 20
+	maxTemplatesWindow = templateBuckets * 60
+	maxTemplatesLimit  = maxTemplates
+)
+
+// TemplatesQuery holds top templates request
+type TemplatesQuery struct {
+	Window int `json:"window,omitempty"` // seconds, up to an hour
+	Limit  int `json:"limit,omitempty"`
+}
+
+// TemplateItem holds template counters
+type TemplateItem struct {
+	ID       int       `json:"id"`
+	Template string    `json:"template"`
+	Count    uint64    `json:"count"` // lines within window
+	Total    uint64    `json:"total"`
+	Seen     time.Time `json:"seen"`
+}
+
+// TemplatesMessage holds outgoing top templates
+type TemplatesMessage struct {
+	Type    string         `json:"type"`
+	Channel string         `json:"channel"`
+	Window  int            `json:"window"`
+	Data    []TemplateItem `json:"data"`
+}
+
+// logTemplate holds template of similar lines
+type logTemplate struct {
+	id     int
+	tokens []string
+	counts rollingCounts
+	total  uint64
+	seen   time.Time
+}
+
+// templateMiner groups channel lines into templates
+type templateMiner struct {
+	// templates by token count and first token
+	groups map[string][]*logTemplate
+	size   int
+	lastID int
+}
+
+// newTemplateMiner creates template miner
+func newTemplateMiner() *templateMiner {
+	return &templateMiner{groups: make(map[string][]*logTemplate)}
+}
+
+// templateTokens splits line into tokens, tokens with digits are wildcards
+func templateTokens(line string) []string {
+	line, _, _ = strings.Cut(line, newline)
+	tokens := strings.Fields(line)
+	if len(tokens) > maxTemplateTokens {
+		tokens = append(tokens[:maxTemplateTokens], templateAny)
+	}
+	for i, t := range tokens {
+		if strings.ContainsAny(t, "0123456789") {
+			tokens[i] = templateAny
+		}
+	}
+	return tokens
+}
+
+// add adds line to the most similar template or creates new one
+// Line is counted if count is set, otherwise it only shapes templates
+func (m *templateMiner) add(line string, now time.Time, count bool) *logTemplate {
+	tokens := templateTokens(line)
+	key := strconv.Itoa(len(tokens))
+	if len(tokens) > 0 {
+		key += space + tokens[0]
+	}
+	var (
+		best    *logTemplate
+		bestSim float64
+	)
+	for _, t := range m.groups[key] {
+		if sim := similarity(t.tokens, tokens); sim >= templateSimilarity && sim > bestSim {
+			best, bestSim = t, sim
+		}
+	}
+	if best == nil {
+		if m.size >= maxTemplates {
+			m.evict()
+		}
+		m.lastID++
+		best = &logTemplate{id: m.lastID, tokens: tokens, counts: newMinuteCounts(templateBuckets)}
+		m.groups[key] = append(m.groups[key], best)
+		m.size++
+	} else {
+		for i, tok := range tokens {
+			if best.tokens[i] != tok {
+				best.tokens[i] = templateAny
+			}
+		}
+	}
+	if count {
+		best.counts.add(now)
+		best.total++
+		best.seen = now
+	}
+	return best
+}
+
+// similarity returns share of equal tokens
+func similarity(template, tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+	equal := 0
+	for i, tok := range tokens {
+		if template[i] == tok {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens))
+}
+
+// evict drops least recently seen template
+func (m *templateMiner) evict() {
+	var (
+		oldKey string
+		oldIdx int
+		old    *logTemplate
+	)
+	for k, group := range m.groups {
+		for i, t := range group {
+			if old == nil || t.seen.Before(old.seen) {
+				oldKey, oldIdx, old = k, i, t
+			}
+		}
+	}
+	if old == nil {
+		return
+	}
+	m.groups[oldKey] = slices.Delete(m.groups[oldKey], oldIdx, oldIdx+1)
+	if len(m.groups[oldKey]) == 0 {
+		delete(m.groups, oldKey)
+	}
+	m.size--
+}
+
+// top returns templates ordered by count within window
+func (m *templateMiner) top(window time.Duration, limit int, now time.Time) []TemplateItem {
+	rv := make([]TemplateItem, 0, m.size)
+	for _, group := range m.groups {
+		for _, t := range group {
+			count := t.counts.count(window, now)
+			if count == 0 {
+				continue
+			}
+			rv = append(rv, TemplateItem{
+				ID:       t.id,
+				Template: strings.Join(t.tokens, space),
+				Count:    count,
+				Total:    t.total,
+				Seen:     t.seen,
+			})
+		}
+	}
+	slices.SortFunc(rv, func(a, b TemplateItem) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.ID, b.ID))
+	})
+	if len(rv) > limit {
+		rv = rv[:limit]
+	}
+	return rv
+}
+
+// TemplateAdd adds log line to channel template miner and returns template id, 0 if miner is disabled
+// Line is counted if it is live, see TailerLive
+func (ts *TailService) TemplateAdd(msg *TailMessage, live bool) int {
+	w, ok := ts.workers[msg.Channel]
+	if !ok || w.Miner == nil || msg.Type != "log" {
+		return 0
+	}
+	return w.Miner.add(msg.Data, time.Now(), live).id
+}
+
+// TemplatesTop returns top templates message of channel, nil if miner is disabled
+func (ts *TailService) TemplatesTop(channel string, query *TemplatesQuery) *TemplatesMessage {
+	w, ok := ts.workers[channel]
+	if !ok || w.Miner == nil {
+		return nil
+	}
+	q := TemplatesQuery{Window: templatesWindow, Limit: templatesLimit}
+	if query != nil {
+		if query.Window > 0 {
+			q.Window = min(query.Window, maxTemplatesWindow)
+		}
+		if query.Limit > 0 {
+			q.Limit = min(query.Limit, maxTemplatesLimit)
+		}
+	}
+	return &TemplatesMessage{
+		Type:    "templates",
+		Channel: channel,
+		Window:  q.Window,
+		Data:    w.Miner.top(time.Duration(q.Window)*time.Second, q.Limit, time.Now()),
+	}
+}
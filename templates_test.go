@@ -0,0 +1,33 @@
+package webtail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateMiner(t *testing.T) {
+	m := newTemplateMiner()
+	now := time.Unix(1700000000, 0)
+	a := m.add("user alice logged in from 10.0.0.1", now.Add(-10*time.Minute), true)
+	b := m.add("user bob logged in from 10.0.0.2", now, true)
+	assert.Equal(t, a.id, b.id)
+	assert.Equal(t, []string{"user", templateAny, "logged", "in", "from", templateAny}, a.tokens)
+	c := m.add("connection closed", now, true)
+	m.add("connection closed", now, true)
+	assert.NotEqual(t, a.id, c.id)
+	m.add("disk full", now, true)
+
+	assert.Equal(t, []TemplateItem{
+		{ID: c.id, Template: "connection closed", Count: 2, Total: 2, Seen: now},
+		{ID: a.id, Template: "user <*> logged in from <*>", Count: 1, Total: 2, Seen: now},
+	}, m.top(5*time.Minute, 2, now))
+	top := m.top(15*time.Minute, 3, now)
+	assert.Equal(t, a.id, top[0].ID, "older line counted in wider window")
+	assert.Equal(t, uint64(2), top[0].Count)
+	assert.Empty(t, m.top(time.Minute, 10, now.Add(2*time.Hour)))
+
+	d := m.add("disk full", now, false)
+	assert.Equal(t, uint64(1), d.total, "old line is not counted")
+}
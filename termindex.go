@@ -0,0 +1,579 @@
+package webtail
+
+// This file holds on-disk inverted index of file terms
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec // used for file names only
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-logr/logr"
+)
+
+// Term index limits
+const (
+	termBlockSize = 1 << 20  // postings granularity, bytes of file
+	termChunkSize = 64 << 20 // bytes of file indexed at once, segment is saved after every chunk
+	termMinLen    = 2
+	termMaxLen    = 64
+	termSegSuffix = ".seg"
+	// changed segments are kept in memory and saved periodically
+	termFlushEvery = 30 * time.Second
+	// saved segments are dropped from memory if not used
+	termHotIdle = 5 * time.Minute
+)
+
+// Metric names
+const (
+	metricTermFiles   = "webtail_term_index_files"
+	metricTermPending = "webtail_term_index_pending_files"
+	metricTermIndexed = "webtail_term_index_indexed_bytes"
+	metricTermDisk    = "webtail_term_index_disk_bytes"
+)
+
+// TermIndexStats holds term index progress
+type TermIndexStats struct {
+	Files   int   `json:"files"`   // files indexed
+	Pending int   `json:"pending"` // files waiting for indexing
+	Indexed int64 `json:"indexed"` // bytes of files indexed
+	Disk    int64 `json:"disk"`    // bytes of index on disk
+}
+
+// termSegment holds postings of a single file
+type termSegment struct {
+	Name    string
+	ModTime time.Time
+	// bytes of file indexed, lines after offset are not indexed yet
+	Offset int64
+	// file is compressed and indexed completely
+	Done bool
+	// term blocks (file offset / termBlockSize), sorted
+	Terms map[string][]uint32
+}
+
+// hotSegment holds segment cached in memory
+type hotSegment struct {
+	seg *termSegment
+	// segment has changes which are not saved
+	dirty bool
+	used  time.Time
+}
+
+// termFile holds indexing request
+type termFile struct {
+	path       string
+	compressed bool
+}
+
+// TermIndex holds on-disk inverted index of file terms
+type TermIndex struct {
+	log     logr.Logger
+	dir     string
+	metrics *Metrics
+	limiter *searchLimiter
+
+	mu      sync.Mutex
+	pending map[string]*termFile
+	// per file: indexed bytes and segment size
+	indexed map[string]int64
+	disk    map[string]int64
+	wake    chan struct{}
+
+	// segments in memory, segments are changed by Run goroutine only
+	hotMu sync.Mutex
+	hot   map[string]*hotSegment
+}
+
+// NewTermIndex creates term index stored in dir
+func NewTermIndex(log logr.Logger, dir string, rate int64, metrics *Metrics) (*TermIndex, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	metrics.Describe(metricTermFiles, MetricGauge, "Files in term index")
+	metrics.Describe(metricTermPending, MetricGauge, "Files waiting for term indexing")
+	metrics.Describe(metricTermIndexed, MetricGauge, "Bytes of files indexed by term index")
+	metrics.Describe(metricTermDisk, MetricGauge, "Term index size on disk")
+	ti := &TermIndex{
+		log:     log.WithValues("worker", "terms"),
+		dir:     dir,
+		metrics: metrics,
+		limiter: newSearchLimiter(1, rate),
+		pending: make(map[string]*termFile),
+		indexed: make(map[string]int64),
+		disk:    make(map[string]int64),
+		wake:    make(chan struct{}, 1),
+		hot:     make(map[string]*hotSegment),
+	}
+	return ti, nil
+}
+
+// Tokenize returns lowercased terms of line
+func Tokenize(line string) []string {
+	var rv []string
+	for _, t := range strings.FieldsFunc(line, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+	}) {
+		if len(t) >= termMinLen && len(t) <= termMaxLen {
+			rv = append(rv, strings.ToLower(t))
+		}
+	}
+	return rv
+}
+
+// Update queues file for indexing, deleted file is removed from index
+// Called by hub
+func (ti *TermIndex) Update(name, path string, attr *IndexItemAttr) {
+	ti.mu.Lock()
+	if attr == nil || attr.Unreadable || (attr.Binary && attr.Compressed == "") {
+		ti.pending[name] = nil
+	} else {
+		ti.pending[name] = &termFile{path: path, compressed: attr.Compressed != ""}
+	}
+	ti.mu.Unlock()
+	ti.notify()
+}
+
+// Sync queues all index files and removes segments of other files
+func (ti *TermIndex) Sync(ts *TailService) error {
+	entries, err := os.ReadDir(ti.dir)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(ts.index))
+	for name, attr := range ts.index {
+		known[ti.segmentName(name)] = true
+		ti.Update(name, ts.channelFile(name), attr)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), termSegSuffix) && !known[e.Name()] {
+			os.Remove(filepath.Join(ti.dir, e.Name()))
+		}
+	}
+	return nil
+}
+
+// notify wakes up indexer
+func (ti *TermIndex) notify() {
+	select {
+	case ti.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run indexes queued files until quit is closed
+func (ti *TermIndex) Run(quit chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-quit
+		cancel()
+	}()
+	ti.log.Info("Term indexer started")
+	ticker := time.NewTicker(termFlushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ti.flush(now, false)
+		default:
+		}
+		name, f, ok := ti.next()
+		if !ok {
+			select {
+			case <-ti.wake:
+				continue
+			case now := <-ticker.C:
+				ti.flush(now, false)
+				continue
+			case <-ctx.Done():
+				ti.flush(time.Now(), true)
+				ti.log.Info("Term indexer stopped")
+				return
+			}
+		}
+		if f == nil {
+			ti.remove(name)
+			continue
+		}
+		more, err := ti.indexFile(ctx, name, f)
+		if err != nil && ctx.Err() == nil {
+			ti.log.Error(err, "Term index", "file", name)
+		}
+		if more {
+			ti.mu.Lock()
+			if _, ok := ti.pending[name]; !ok {
+				// continue after other files
+				ti.pending[name] = f
+			}
+			ti.mu.Unlock()
+		}
+	}
+}
+
+// next returns queued file
+func (ti *TermIndex) next() (string, *termFile, bool) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	for name, f := range ti.pending {
+		delete(ti.pending, name)
+		return name, f, true
+	}
+	ti.updateMetrics()
+	return "", nil, false
+}
+
+// Stats returns index progress
+func (ti *TermIndex) Stats() *TermIndexStats {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return ti.stats()
+}
+
+func (ti *TermIndex) stats() *TermIndexStats {
+	rv := &TermIndexStats{Files: len(ti.indexed), Pending: len(ti.pending)}
+	for _, v := range ti.indexed {
+		rv.Indexed += v
+	}
+	for _, v := range ti.disk {
+		rv.Disk += v
+	}
+	return rv
+}
+
+// updateMetrics sets metrics by stats, ti.mu must be locked
+func (ti *TermIndex) updateMetrics() {
+	st := ti.stats()
+	ti.metrics.Set(metricTermFiles, float64(st.Files))
+	ti.metrics.Set(metricTermPending, float64(st.Pending))
+	ti.metrics.Set(metricTermIndexed, float64(st.Indexed))
+	ti.metrics.Set(metricTermDisk, float64(st.Disk))
+}
+
+// segmentName returns segment file name
+func (ti *TermIndex) segmentName(name string) string {
+	sum := sha1.Sum([]byte(name)) //nolint:gosec // used for file names only
+	return hex.EncodeToString(sum[:]) + termSegSuffix
+}
+
+// load reads file segment, nil if file is not indexed
+func (ti *TermIndex) load(name string) (*termSegment, error) {
+	fh, err := os.Open(filepath.Join(ti.dir, ti.segmentName(name)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fh.Close()
+	seg := &termSegment{}
+	if err = gob.NewDecoder(bufio.NewReader(fh)).Decode(seg); err != nil {
+		return nil, err
+	}
+	if seg.Name != name {
+		// hash collision
+		return nil, nil
+	}
+	return seg, nil
+}
+
+// save writes segment atomically
+func (ti *TermIndex) save(seg *termSegment) error {
+	file := filepath.Join(ti.dir, ti.segmentName(seg.Name))
+	tmp, err := os.CreateTemp(ti.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(seg)
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	ti.accountDisk(seg.Name)
+	return nil
+}
+
+// account updates index stats by segment
+func (ti *TermIndex) account(seg *termSegment) {
+	ti.mu.Lock()
+	ti.indexed[seg.Name] = seg.Offset
+	ti.updateMetrics()
+	ti.mu.Unlock()
+}
+
+// accountDisk updates index stats by saved segment
+func (ti *TermIndex) accountDisk(name string) {
+	fi, err := os.Stat(filepath.Join(ti.dir, ti.segmentName(name)))
+	if err != nil {
+		return
+	}
+	ti.mu.Lock()
+	ti.disk[name] = fi.Size()
+	ti.updateMetrics()
+	ti.mu.Unlock()
+}
+
+// segment returns file segment from memory or disk, nil if file is not indexed
+func (ti *TermIndex) segment(name string) (*termSegment, error) {
+	ti.hotMu.Lock()
+	defer ti.hotMu.Unlock()
+	if h, ok := ti.hot[name]; ok {
+		h.used = time.Now()
+		return h.seg, nil
+	}
+	seg, err := ti.load(name)
+	if seg != nil {
+		ti.hot[name] = &hotSegment{seg: seg, used: time.Now()}
+	}
+	return seg, err
+}
+
+// flush saves changed segments and drops unused ones from memory
+// All segments are saved and dropped if force is set
+func (ti *TermIndex) flush(now time.Time, force bool) {
+	ti.hotMu.Lock()
+	var dirty []*termSegment
+	for name, h := range ti.hot {
+		if h.dirty {
+			dirty = append(dirty, h.seg)
+			h.dirty = false
+			if !force {
+				// kept till saved
+				continue
+			}
+		}
+		if force || now.Sub(h.used) > termHotIdle {
+			delete(ti.hot, name)
+		}
+	}
+	ti.hotMu.Unlock()
+	// segments are changed by caller goroutine only, so they are encoded without lock
+	for _, seg := range dirty {
+		if err := ti.save(seg); err != nil {
+			ti.log.Error(err, "Segment save", "file", seg.Name)
+		}
+	}
+}
+
+// remove removes file segment
+func (ti *TermIndex) remove(name string) {
+	ti.hotMu.Lock()
+	delete(ti.hot, name)
+	ti.hotMu.Unlock()
+	os.Remove(filepath.Join(ti.dir, ti.segmentName(name)))
+	ti.mu.Lock()
+	delete(ti.indexed, name)
+	delete(ti.disk, name)
+	ti.updateMetrics()
+	ti.mu.Unlock()
+}
+
+// indexFile indexes next chunk of file, returns true if file has more data
+// Segment is changed in memory and saved by flush
+func (ti *TermIndex) indexFile(ctx context.Context, name string, f *termFile) (bool, error) {
+	seg, err := ti.segment(name)
+	if err != nil {
+		ti.log.Error(err, "Segment load, reindex", "file", name)
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		ti.remove(name)
+		return false, err
+	}
+	if seg != nil && (seg.Offset > fi.Size() && !f.compressed || f.compressed && !seg.ModTime.Equal(fi.ModTime())) {
+		// file was truncated or replaced
+		seg = nil
+	}
+	if seg == nil {
+		seg = &termSegment{Name: name, Terms: make(map[string][]uint32)}
+	}
+	if seg.Done || (!f.compressed && seg.Offset == fi.Size()) {
+		// indexed already
+		ti.account(seg)
+		ti.accountDisk(name)
+		return false, nil
+	}
+	// lines are collected in delta which is merged into segment under lock
+	delta := &termSegment{Name: name, ModTime: fi.ModTime(), Offset: seg.Offset, Terms: make(map[string][]uint32)}
+	var r io.ReadCloser
+	if f.compressed {
+		// compressed file is indexed at once
+		r, _, err = openLog(f.path)
+		delta.Offset = 0
+	} else {
+		var fh *os.File
+		fh, err = os.Open(f.path)
+		if err == nil {
+			_, err = fh.Seek(delta.Offset, io.SeekStart)
+		}
+		r = fh
+	}
+	if err != nil {
+		if r != nil {
+			r.Close()
+		}
+		return false, err
+	}
+	defer r.Close()
+	more := false
+	br := bufio.NewReader(limitedReader{ctx: ctx, r: r, limiter: ti.limiter})
+	start := delta.Offset
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if err == io.EOF && !f.compressed {
+			// incomplete line is indexed when completed
+			break
+		}
+		delta.add(delta.Offset, line)
+		delta.Offset += int64(len(line))
+		if err == io.EOF {
+			delta.Done = true
+			break
+		}
+		if !f.compressed && delta.Offset-start >= termChunkSize {
+			more = true
+			break
+		}
+	}
+	ti.merge(seg, delta, f.compressed)
+	ti.account(seg)
+	return more, nil
+}
+
+// merge adds delta postings into segment and marks it as changed
+// Compressed file segment is replaced by delta
+func (ti *TermIndex) merge(seg, delta *termSegment, replace bool) {
+	ti.hotMu.Lock()
+	defer ti.hotMu.Unlock()
+	if replace {
+		seg.Terms = delta.Terms
+	} else {
+		for t, blocks := range delta.Terms {
+			cur := seg.Terms[t]
+			if n := len(cur); n > 0 && cur[n-1] == blocks[0] {
+				blocks = blocks[1:]
+			}
+			seg.Terms[t] = append(cur, blocks...)
+		}
+	}
+	seg.ModTime, seg.Offset, seg.Done = delta.ModTime, delta.Offset, delta.Done
+	h, ok := ti.hot[seg.Name]
+	if !ok {
+		h = &hotSegment{seg: seg}
+		ti.hot[seg.Name] = h
+	}
+	h.seg, h.dirty, h.used = seg, true, time.Now()
+}
+
+// add adds line terms into segment
+func (seg *termSegment) add(offset int64, line string) {
+	block := uint32(offset / termBlockSize)
+	for _, t := range Tokenize(line) {
+		blocks := seg.Terms[t]
+		if n := len(blocks); n == 0 || blocks[n-1] != block {
+			seg.Terms[t] = append(blocks, block)
+		}
+	}
+}
+
+// termPlan holds parts of file which have to be scanned for terms
+type termPlan struct {
+	// candidate blocks
+	blocks []uint32
+	// file is not indexed after offset
+	offset int64
+	// compressed file has to be scanned whole if any block found
+	compressed bool
+}
+
+// Lookup returns scan plan for file lines containing all terms, nil if file is not indexed
+func (ti *TermIndex) Lookup(name string, terms []string, compressed bool) *termPlan {
+	seg, err := ti.segment(name)
+	if err != nil || seg == nil {
+		return nil
+	}
+	ti.hotMu.Lock()
+	defer ti.hotMu.Unlock()
+	if compressed && !seg.Done {
+		return nil
+	}
+	plan := &termPlan{offset: seg.Offset, compressed: compressed}
+	for i, t := range terms {
+		blocks := seg.Terms[t]
+		if i == 0 {
+			plan.blocks = slices.Clone(blocks)
+			continue
+		}
+		plan.blocks = slices.DeleteFunc(plan.blocks, func(b uint32) bool {
+			_, found := slices.BinarySearch(blocks, b)
+			return !found
+		})
+	}
+	return plan
+}
+
+// TermIndexRun starts term indexer if enabled
+func (ts *TailService) TermIndexRun(quit chan struct{}, wg *sync.WaitGroup) {
+	if ts.terms == nil {
+		return
+	}
+	ts.TermSync()
+	go ts.terms.Run(quit, wg)
+}
+
+// TermSync queues all index files for term indexing
+func (ts *TailService) TermSync() {
+	if ts.terms == nil {
+		return
+	}
+	if err := ts.terms.Sync(ts); err != nil {
+		ts.log.Error(err, "Term index sync")
+	}
+}
+
+// TermUpdate queues changed file for term indexing
+func (ts *TailService) TermUpdate(msg *IndexItemEvent) {
+	if ts.terms == nil {
+		return
+	}
+	if msg.RenamedFrom != "" {
+		ts.terms.Update(msg.RenamedFrom, "", nil)
+	}
+	if msg.Deleted {
+		ts.terms.Update(msg.Name, "", nil)
+		return
+	}
+	ts.terms.Update(msg.Name, ts.channelFile(msg.Name), ts.index[msg.Name])
+}
+
+// TermStats returns term index progress, nil if index is disabled
+func (ts *TailService) TermStats() *TermIndexStats {
+	if ts.terms == nil {
+		return nil
+	}
+	return ts.terms.Stats()
+}
@@ -0,0 +1,92 @@
+package webtail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTermIndex(t *testing.T) {
+	assert.Equal(t, []string{"req-42", "started", "user_id"}, Tokenize("a req-42 Started: user_id=1"))
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "logs")
+	require.NoError(t, os.Mkdir(root, 0o750))
+	line := strings.Repeat("x", 99) + "\n" // 100 bytes
+	pad := strings.Repeat(line, termBlockSize/100+1)
+	data := pad + "block one req-42\n" + pad
+	require.NoError(t, os.WriteFile(filepath.Join(root, "app.log"), []byte(data), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "other.log"), []byte("nothing\n"), 0o600))
+
+	cfg := &Config{Root: root, StateDir: filepath.Join(dir, "state"), SearchWorkers: 1}
+	ts, err := NewTailService(logr.Discard(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, loadIndex(ts.index, ts.roots[0], time.Now()))
+	require.NoError(t, ts.terms.Sync(ts))
+	for name, f, ok := ts.terms.next(); ok; name, f, ok = ts.terms.next() {
+		_, err = ts.terms.indexFile(context.Background(), name, f)
+		require.NoError(t, err)
+	}
+	st := ts.TermStats()
+	assert.Zero(t, st.Disk, "segments are saved by flush")
+	ts.terms.flush(time.Now(), false)
+	st = ts.TermStats()
+	assert.Equal(t, 2, st.Files)
+	assert.Equal(t, int64(len(data)+8), st.Indexed)
+	assert.Positive(t, st.Disk)
+
+	plan := ts.terms.Lookup("app.log", []string{"req-42", "one"}, false)
+	require.NotNil(t, plan)
+	assert.Equal(t, []uint32{1}, plan.blocks)
+	assert.Equal(t, []byteRange{{termBlockSize, 2 * termBlockSize}}, plan.ranges(int64(len(data))))
+	assert.Empty(t, ts.terms.Lookup("other.log", []string{"req-42"}, false).blocks)
+
+	// appended lines are not indexed yet
+	f, err := os.OpenFile(filepath.Join(root, "other.log"), os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString("late req-42 one\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	job, err := ts.prepareSearch(&SearchQuery{Pattern: "REQ-42 one", Term: true})
+	require.NoError(t, err)
+	var got []SearchMessage
+	job.run(context.Background(), func(msg *SearchMessage) bool {
+		got = append(got, *msg)
+		return true
+	})
+	require.Len(t, got, 3)
+	assert.Equal(t, []SearchHit{{Offset: int64(len(pad)) + 17, Line: "block one req-42"}}, got[0].Data)
+	assert.Equal(t, []SearchHit{{Offset: 24, Line: "late req-42 one"}}, got[1].Data)
+	assert.Equal(t, SearchMessage{Type: "search_done", Files: 2, Hits: 2}, got[2])
+
+	job, err = ts.prepareSearch(&SearchQuery{Pattern: "nothing", Term: true, Glob: "app.log"})
+	require.NoError(t, err)
+	got = nil
+	job.run(context.Background(), func(msg *SearchMessage) bool {
+		got = append(got, *msg)
+		return true
+	})
+	assert.Equal(t, []SearchMessage{{Type: "search_done", Indexed: 1}}, got)
+
+	// appended lines are merged into segment in memory
+	ts.terms.Update("other.log", filepath.Join(root, "other.log"), ts.index["other.log"])
+	name, tf, ok := ts.terms.next()
+	require.True(t, ok)
+	_, err = ts.terms.indexFile(context.Background(), name, tf)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{0}, ts.terms.Lookup("other.log", []string{"late"}, false).blocks)
+	ts.terms.flush(time.Now(), true)
+	assert.Empty(t, ts.terms.hot)
+	seg, err := ts.terms.load("other.log")
+	require.NoError(t, err)
+	assert.Equal(t, int64(24), seg.Offset)
+	assert.Equal(t, []uint32{0}, seg.Terms["late"])
+}
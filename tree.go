@@ -0,0 +1,231 @@
+package webtail
+
+// This file holds directory tree view of index
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TreeItem holds directory child attrs
+// For subdirectory, Size and ModTime are aggregated over all files inside it
+type TreeItem struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	Files   int       `json:"files,omitempty"`
+	ModTime time.Time `json:"mtime"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// TreeMessage holds outgoing directory listing or update
+type TreeMessage struct {
+	Type    string     `json:"type"`
+	Channel string     `json:"channel"`
+	Data    []TreeItem `json:"data"`
+}
+
+// dirNode holds directory children and cached aggregates
+type dirNode struct {
+	files map[string]bool
+	dirs  map[string]bool
+	// aggregated attrs, nil if changed
+	stat *TreeItem
+}
+
+// Tree errors
+const (
+	MsgUnknownDir = "unknown directory"
+)
+
+// treeRebuild creates tree from index
+func (ts *TailService) treeRebuild() {
+	ts.tree = map[string]*dirNode{"": newDirNode()}
+	for k := range ts.index {
+		ts.treeAdd(k)
+	}
+}
+
+func newDirNode() *dirNode {
+	return &dirNode{files: make(map[string]bool), dirs: make(map[string]bool)}
+}
+
+// treeAdd adds file into tree
+func (ts *TailService) treeAdd(name string) {
+	dir, file := splitPath(name)
+	child := dir
+	node, ok := ts.tree[dir]
+	if !ok {
+		node = newDirNode()
+		ts.tree[dir] = node
+	}
+	node.files[file] = true
+	for child != "" && !ok {
+		// register new directory in parents
+		parent, base := splitPath(child)
+		var pnode *dirNode
+		pnode, ok = ts.tree[parent]
+		if !ok {
+			pnode = newDirNode()
+			ts.tree[parent] = pnode
+		}
+		pnode.dirs[base] = true
+		child = parent
+	}
+	ts.treeTouch(dir)
+}
+
+// treeDelete removes file from tree, empty directories are removed too
+func (ts *TailService) treeDelete(name string) {
+	dir, file := splitPath(name)
+	node, ok := ts.tree[dir]
+	if !ok {
+		return
+	}
+	delete(node.files, file)
+	ts.treeTouch(dir)
+	for dir != "" && len(node.files) == 0 && len(node.dirs) == 0 {
+		delete(ts.tree, dir)
+		parent, base := splitPath(dir)
+		node = ts.tree[parent]
+		delete(node.dirs, base)
+		dir = parent
+	}
+}
+
+// treeTouch drops cached aggregates of dir and its parents
+func (ts *TailService) treeTouch(dir string) {
+	for {
+		if node, ok := ts.tree[dir]; ok {
+			node.stat = nil
+		}
+		if dir == "" {
+			return
+		}
+		dir, _ = splitPath(dir)
+	}
+}
+
+// TreeList returns sorted directory children, false if directory does not exist
+func (ts *TailService) TreeList(dir string) ([]TreeItem, bool) {
+	node, ok := ts.tree[dir]
+	if !ok {
+		return nil, false
+	}
+	rv := make([]TreeItem, 0, len(node.dirs)+len(node.files))
+	for name := range node.dirs {
+		rv = append(rv, ts.dirStat(path.Join(dir, name)))
+	}
+	for name := range node.files {
+		rv = append(rv, ts.fileStat(path.Join(dir, name)))
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name < rv[j].Name })
+	return rv, true
+}
+
+// TreeItem returns directory child attrs, Deleted is set if child does not exist
+func (ts *TailService) TreeItem(dir, name string) TreeItem {
+	p := path.Join(dir, name)
+	if _, ok := ts.index[p]; ok {
+		return ts.fileStat(p)
+	}
+	if _, ok := ts.tree[p]; ok {
+		return ts.dirStat(p)
+	}
+	return TreeItem{Name: name, Deleted: true}
+}
+
+// fileStat returns attrs of file
+func (ts *TailService) fileStat(name string) TreeItem {
+	_, base := splitPath(name)
+	attr := ts.index[name]
+	return TreeItem{Name: base, Size: attr.Size, ModTime: attr.ModTime}
+}
+
+// dirStat returns aggregated attrs of directory
+func (ts *TailService) dirStat(dir string) TreeItem {
+	node := ts.tree[dir]
+	if node.stat != nil {
+		return *node.stat
+	}
+	_, base := splitPath(dir)
+	rv := TreeItem{Name: base, Dir: true}
+	add := func(item TreeItem) {
+		rv.Size += item.Size
+		rv.Files += item.Files
+		if item.ModTime.After(rv.ModTime) {
+			rv.ModTime = item.ModTime
+		}
+	}
+	for name := range node.files {
+		add(ts.fileStat(path.Join(dir, name)))
+		rv.Files++
+	}
+	for name := range node.dirs {
+		add(ts.dirStat(path.Join(dir, name)))
+	}
+	node.stat = &rv
+	return rv
+}
+
+// splitPath splits slash separated path into dir and base name
+func splitPath(name string) (string, string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// treeExpand sends directory listing and subscribes client on its changes
+func (h *Hub) treeExpand(dir string, client *Client) []byte {
+	items, ok := h.workers.TreeList(dir)
+	if !ok {
+		return formatTailMessage(dir, "tree", MsgUnknownDir, false)
+	}
+	expanded, ok := h.trees[client]
+	if !ok {
+		expanded = make(map[string]bool)
+		h.trees[client] = expanded
+	}
+	expanded[dir] = true
+	data, _ := json.Marshal(TreeMessage{Type: "tree", Channel: dir, Data: items})
+	return data
+}
+
+// treeCollapse unsubscribes client from directory changes
+func (h *Hub) treeCollapse(dir string, client *Client) []byte {
+	if !h.trees[client][dir] {
+		return formatTailMessage(dir, "collapse", MsgNotSubscribed, false)
+	}
+	delete(h.trees[client], dir)
+	return formatTailMessage(dir, "collapse", MsgUnSubscribed, true)
+}
+
+// treeNotify sends updated child item to clients which expanded any parent of changed file
+func (h *Hub) treeNotify(name string) {
+	if len(h.trees) == 0 {
+		return
+	}
+	child := name
+	for {
+		dir, base := splitPath(child)
+		var data []byte
+		for client, expanded := range h.trees {
+			if !expanded[dir] {
+				continue
+			}
+			if data == nil {
+				data, _ = json.Marshal(TreeMessage{Type: "tree_update", Channel: dir, Data: []TreeItem{h.workers.TreeItem(dir, base)}})
+			}
+			h.send(client, data)
+		}
+		if dir == "" {
+			return
+		}
+		child = dir
+	}
+}
@@ -0,0 +1,39 @@
+package webtail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree(t *testing.T) {
+	now := time.Now()
+	ts := &TailService{Config: &Config{}, rules: &Rules{}, index: IndexItemAttrStore{
+		"a.log":             {Size: 1, ModTime: now.Add(-time.Hour)},
+		"journal/x/1.log":   {Size: 10, ModTime: now.Add(-time.Minute)},
+		"journal/x/2.log":   {Size: 20, ModTime: now},
+		"journal/y/old.log": {Size: 5, ModTime: now.Add(-time.Hour)},
+	}}
+	ts.treeRebuild()
+
+	items, ok := ts.TreeList("")
+	assert.True(t, ok)
+	assert.Equal(t, []TreeItem{
+		{Name: "a.log", Size: 1, ModTime: now.Add(-time.Hour)},
+		{Name: "journal", Dir: true, Size: 35, Files: 3, ModTime: now},
+	}, items)
+
+	ts.IndexUpdate(&IndexItemEvent{Name: "journal/y/old.log", Deleted: true})
+	assert.Equal(t, TreeItem{Name: "y", Deleted: true}, ts.TreeItem("journal", "y"))
+	assert.Equal(t, TreeItem{Name: "journal", Dir: true, Size: 30, Files: 2, ModTime: now}, ts.TreeItem("", "journal"))
+
+	ts.IndexUpdate(&IndexItemEvent{Name: "journal/z/new.log", IndexItemAttr: IndexItemAttr{Size: 7, ModTime: now}})
+	items, _ = ts.TreeList("journal")
+	assert.Equal(t, []TreeItem{
+		{Name: "x", Dir: true, Size: 30, Files: 2, ModTime: now},
+		{Name: "z", Dir: true, Size: 7, Files: 1, ModTime: now},
+	}, items)
+	_, ok = ts.TreeList("journal/y")
+	assert.False(t, ok)
+}
@@ -4,6 +4,7 @@ package webtail
 
 import (
 	"net/http"
+	"slices"
 	"sync"
 
 	"github.com/go-logr/logr"
@@ -13,7 +14,7 @@ import (
 
 // Config defines local application flags
 type Config struct {
-	Root        string `long:"root"  default:"log/"  description:"Root directory for log files"`
+	Root        string `long:"root"  default:"log/"  description:"Root directory for log files (if roots are not set)"`
 	Bytes       int64  `long:"bytes" default:"5000"  description:"tail from the last Nth location"`
 	Lines       int    `long:"lines" default:"100"   description:"keep N old lines for new consumers"`
 	MaxLineSize int    `long:"split" default:"180"   description:"split line if longer"`
@@ -24,13 +25,59 @@ type Config struct {
 	ClientBufferSize  int `long:"out_buf"      default:"256"  description:"Client Buffer Size"`
 	WSReadBufferSize  int `long:"ws_read_buf"  default:"1024" description:"WS Read Buffer Size"`
 	WSWriteBufferSize int `long:"ws_write_buf" default:"1024" description:"WS Write Buffer Size"`
+
+	BufferBytes      int64 `long:"buf_bytes" default:"1048576"  description:"keep at most N bytes of old lines per channel (0 - no limit)"`
+	BufferTotalBytes int64 `long:"buf_total" default:"67108864" description:"keep at most N bytes of old lines for all channels (0 - no limit)"`
+
+	Rules string   `long:"rules" description:"Per-file rules file (yaml)"`
+	Roots []string `long:"roots" description:"Named root directory (name=path), channels are prefixed by name/"`
+
+	IndexPoll     int `long:"index_poll"      default:"0"    description:"Rescan roots every N sec instead of inotify (for NFS, FUSE), 0 - use inotify"`
+	IndexPollRate int `long:"index_poll_rate" default:"5000" description:"Max files per second to stat while rescan (0 - no limit)"`
+	IndexDebounce int `long:"index_debounce"  default:"1000" description:"Coalesce file write events within N msec (0 - send every event)"`
+
+	HistoryLines int      `long:"history_lines" default:"1000" description:"Max lines per history page"`
+	Rotation     []string `long:"rotation" default:"\\.\\d+(\\.(gz|zst|bz2))?" default:"-\\d{8}(\\.(gz|zst|bz2))?" description:"Rotated file name suffix (regexp), history continues into rotated files"`
+
+	SearchWorkers int   `long:"search_workers" default:"4"        description:"Max files scanned concurrently by all searches"`
+	SearchRate    int64 `long:"search_rate"    default:"52428800" description:"Max bytes per second read by all searches (0 - no limit)"`
+	SearchHits    int   `long:"search_hits"    default:"1000"     description:"Max hits per search (0 - no limit)"`
+
+	StateDir      string `long:"state_dir"  description:"Directory for search term index (index is disabled if empty)"`
+	TermIndexRate int64  `long:"term_rate"  default:"10485760" description:"Max bytes per second read by term indexer (0 - no limit)"`
+
+	AlertWebhooks []string `long:"alert_webhook" description:"Webhook URL for alert events of rules without own webhooks"`
+	AlertRetries  int      `long:"alert_retries" default:"3" description:"Retries of failed alert webhook delivery"`
+	AlertMaxPins  int      `long:"alert_max_pins" default:"100" description:"Max files tailed for alert rules without subscribers (0 - no limit)"`
+
+	AnomalyInterval int     `long:"anomaly_interval" default:"60" description:"Line rate interval (sec) for anomaly detection"`
+	AnomalyFactor   float64 `long:"anomaly_factor"   default:"5"  description:"Report anomaly if line rate differs from baseline by this factor (0 - disabled)"`
+	AnomalySilence  int     `long:"anomaly_silence"  default:"5"  description:"Report silence of growing file after N empty intervals (0 - disabled)"`
+
+	UserHeader     string   `long:"user_header"   description:"Request header with user name set by auth proxy (used for redaction exemptions)"`
+	GroupsHeader   string   `long:"groups_header" description:"Request header with comma separated user groups set by auth proxy"`
+	TrustedProxies []string `long:"trusted_proxy" default:"127.0.0.1/32" default:"::1/128" description:"Auth proxy network (CIDR), user and groups headers of requests from other addresses are ignored"`
+
+	AuditFile   string `long:"audit_file"   description:"Audit log file (json lines), audit is disabled if empty and syslog is off"`
+	AuditSize   int64  `long:"audit_size"   default:"104857600" description:"Rotate audit file when it exceeds N bytes (0 - no rotation)"`
+	AuditKeep   int    `long:"audit_keep"   default:"7" description:"Keep N rotated audit files"`
+	AuditSyslog bool   `long:"audit_syslog" description:"Send audit events to syslog"`
 }
 
 // codebeat:enable[TOO_MANY_IVARS]
 
+// clone returns config copy, slices are copied too
+func (cfg *Config) clone() *Config {
+	rv := *cfg
+	rv.Roots = slices.Clone(cfg.Roots)
+	rv.Rotation = slices.Clone(cfg.Rotation)
+	rv.AlertWebhooks = slices.Clone(cfg.AlertWebhooks)
+	rv.TrustedProxies = slices.Clone(cfg.TrustedProxies)
+	return &rv
+}
+
 // Service holds WebTail service
 type Service struct {
-	cfg *Config
 	hub *Hub
 	wg  *sync.WaitGroup
 	log logr.Logger
@@ -38,14 +85,20 @@ type Service struct {
 
 // New creates WebTail service
 func New(log logr.Logger, cfg *Config) (*Service, error) {
+	// service gets its own copy, so caller may change cfg
+	cfg = cfg.clone()
 	tail, err := NewTailService(log, cfg)
 	if err != nil {
 		return nil, err
 	}
+	audit, err := newAuditLog(log, cfg, tail.metrics)
+	if err != nil {
+		return nil, err
+	}
 	var wg sync.WaitGroup
 	hub := NewHub(log, tail, &wg)
-	service := Service{cfg: cfg, hub: hub, log: log, wg: &wg}
-	return &service, nil
+	hub.audit = audit
+	return &Service{hub: hub, log: log, wg: &wg}, nil
 }
 
 // Run runs a message hub
@@ -58,21 +111,27 @@ func (wt *Service) Close() {
 	wt.log.Info("Service Exiting")
 	wt.hub.Close()
 	wt.wg.Wait()
+	wt.hub.audit.Close()
+}
+
+// Metrics returns service metrics handler
+func (wt *Service) Metrics() http.Handler {
+	return wt.hub.workers.metrics
 }
 
 // Handle handles websocket requests from the peer
 func (wt *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	wsUpgrader := upgrader(wt.cfg.WSReadBufferSize, wt.cfg.WSWriteBufferSize)
+	cfg := wt.hub.config.Load()
+	wsUpgrader := upgrader(cfg.WSReadBufferSize, cfg.WSWriteBufferSize)
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		wt.log.Error(err, "Upgrade connection")
 		return
 	}
-	client := &Client{
-		conn: conn,
-		send: make(chan []byte, wt.cfg.ClientBufferSize),
-		log:  wt.log,
-	}
+	client := wt.requestClient(r)
+	client.conn = conn
+	client.send = make(chan []byte, cfg.ClientBufferSize)
+	client.log = wt.log
 	wt.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
@@ -80,3 +139,23 @@ func (wt *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	go client.runWritePump(wt.wg)
 	go client.runReadPump(wt.wg, wt.hub.unregister, wt.hub.broadcast)
 }
+
+// requestClient returns client with identity of request
+func (wt *Service) requestClient(r *http.Request) *Client {
+	cfg := wt.hub.config.Load()
+	client := &Client{addr: r.RemoteAddr}
+	if !trustedProxy(cfg.TrustedProxies, r.RemoteAddr) {
+		// headers may be set by client itself
+		return client
+	}
+	if addr := forwardedAddr(cfg.TrustedProxies, r.Header); addr != "" {
+		client.addr = addr
+	}
+	if cfg.UserHeader != "" {
+		client.user = r.Header.Get(cfg.UserHeader)
+	}
+	if cfg.GroupsHeader != "" {
+		client.groups = parseGroups(r.Header.Get(cfg.GroupsHeader))
+	}
+	return client
+}
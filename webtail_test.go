@@ -1,10 +1,16 @@
 package webtail_test
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -72,6 +78,10 @@ func (ss *ServerSuite) TestSimpleCommands() {
 			name: "Try to unsubscribe from unexistent channel",
 			cmd:  &webtail.InMessage{Type: "detach", Channel: ".notexists"},
 			want: []string{`{"channel":".notexists","data":"unknown channel","type":"error"}`},
+		}, {
+			name: "Try to subscribe with too long filter expression",
+			cmd:  &webtail.InMessage{Type: "attach", Channel: "file.log", Filter: &webtail.TailFilter{Expr: strings.Repeat("a", 4097)}},
+			want: []string{`{"channel":"file.log","data":"expr: expression too long at position 4097","pos":4097,"type":"error"}`},
 		}, {
 			name: "Subscribe on file",
 			cmd:  &webtail.InMessage{Type: "attach", Channel: "subdir/another.log"},
@@ -79,7 +89,7 @@ func (ss *ServerSuite) TestSimpleCommands() {
 		}, {
 			name: "Set subscriber count",
 			cmd:  &webtail.InMessage{Type: "stats"},
-			want: []string{`{"data":{"subdir/another.log":1},"type":"stats"}`},
+			want: []string{`{"buffer":{"channels":{"subdir/another.log":0},"total":0},"data":{"subdir/another.log":1},"type":"stats"}`},
 		}, {
 			name: "Try to subscribe again",
 			cmd:  &webtail.InMessage{Type: "attach", Channel: "subdir/another.log"},
@@ -117,9 +127,8 @@ func (ss *ServerSuite) TestTailInit() {
 	err = f.Close()
 	require.NoError(ss.T(), err)
 	want = []string{
-		`{"data":{"name":"file.log","size":28},"type":"index"}`,
+		`{"data":[{"encoding":"utf-8","name":"file.log","size":28},{"encoding":"utf-8","name":"subdir/another.log","size":22}],"type":"index_list","version":1}`,
 		`{"data":{"name":"file1.log","size":0},"type":"index"}`,
-		`{"data":{"name":"subdir/another.log","size":22},"type":"index"}`,
 	}
 	wtc.WaitSync(len(want)) // wait for RootFile create
 	got = wtc.Receive(len(want), true)
@@ -153,6 +162,114 @@ func (ss *ServerSuite) TestTailInit() {
 	require.Equal(ss.T(), want, got)
 }
 
+func (ss *ServerSuite) TestReload() {
+	cfg := ss.cfg // Reload changes config
+	wtc, err := NewWebTailClient(ss.T(), &cfg)
+	require.NoError(ss.T(), err)
+	defer wtc.Close()
+	go wtc.Listener(5)
+
+	want := []string{
+		`{"data":"success","type":"attach"}`,
+		`{"data":[{"encoding":"utf-8","name":"file.log","size":28},{"encoding":"utf-8","name":"subdir/another.log","size":22}],"type":"index_list","version":1}`,
+	}
+	got := wtc.Call(&webtail.InMessage{Type: "attach"}, len(want), false)
+	require.Equal(ss.T(), want, got)
+
+	newCfg := ss.cfg
+	newCfg.Root = filepath.Join("testdata", "subdir")
+	err = wtc.wtServer.Reload(&newCfg)
+	require.NoError(ss.T(), err)
+
+	want = []string{`{"data":"config reloaded","type":"detach"}`}
+	got = wtc.Receive(len(want), false)
+	require.Equal(ss.T(), want, got)
+
+	want = []string{
+		`{"data":"success","type":"attach"}`,
+		`{"data":[{"encoding":"utf-8","name":"another.log","size":22}],"type":"index_list","version":2}`,
+	}
+	got = wtc.Call(&webtail.InMessage{Type: "attach"}, len(want), false)
+	require.Equal(ss.T(), want, got)
+}
+
+func (ss *ServerSuite) TestReloadStopped() {
+	cfg := ss.cfg
+	wt, err := webtail.New(logr.Discard(), &cfg)
+	require.NoError(ss.T(), err)
+	go wt.Run()
+	wt.Close()
+	newCfg := ss.cfg
+	require.ErrorIs(ss.T(), wt.Reload(&newCfg), webtail.ErrServiceStopped)
+}
+
+func (ss *ServerSuite) TestIndexAPI() {
+	wtc, err := NewWebTailClient(ss.T(), &ss.cfg)
+	require.NoError(ss.T(), err)
+	defer wtc.Close()
+	go wtc.Listener(1)
+
+	var rec *httptest.ResponseRecorder
+	require.Eventually(ss.T(), func() bool {
+		rec = httptest.NewRecorder()
+		wtc.wtServer.ServeIndex(rec, httptest.NewRequest(http.MethodGet, "/api/index", nil))
+		return rec.Code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	etag := rec.Header().Get("ETag")
+	require.Regexp(ss.T(), `^"\w+-1"$`, etag)
+	require.Contains(ss.T(), rec.Body.String(), `"name":"subdir/another.log"`)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/index", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	wtc.wtServer.ServeIndex(rec, req)
+	require.Equal(ss.T(), http.StatusNotModified, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/index", nil)
+	req.Header.Set("If-None-Match", `"1"`)
+	rec = httptest.NewRecorder()
+	wtc.wtServer.ServeIndex(rec, req)
+	require.Equal(ss.T(), http.StatusOK, rec.Code, "version of other process")
+}
+
+func (ss *ServerSuite) TestSearch() {
+	wtc, err := NewWebTailClient(ss.T(), &ss.cfg)
+	require.NoError(ss.T(), err)
+	defer wtc.Close()
+	go wtc.Listener(2)
+
+	want := []string{
+		`{"data":[{"before":["Some log data"],"line":"with two lines","offset":28}],"file":"file.log","id":"1","type":"search_result"}`,
+		`{"files":2,"hits":1,"id":"1","type":"search_done"}`,
+	}
+	got := wtc.Call(&webtail.InMessage{Type: "search", Search: &webtail.SearchQuery{ID: "1", Pattern: "TWO", IgnoreCase: true, Context: 1}}, len(want), false)
+	require.Equal(ss.T(), want, got)
+
+	rec := httptest.NewRecorder()
+	wtc.wtServer.ServeSearch(rec, httptest.NewRequest(http.MethodGet, "/api/search?pattern=another&glob=subdir/*", nil))
+	require.Equal(ss.T(), http.StatusOK, rec.Code)
+	require.Equal(ss.T(), `{"type":"search_result","file":"subdir/another.log","data":[{"offset":22,"line":"Some another log data"}]}
+{"type":"search_done","files":1,"hits":1}
+`, rec.Body.String())
+
+	term := httptest.NewRecorder()
+	wtc.wtServer.ServeSearch(term, httptest.NewRequest(http.MethodGet, "/api/search?pattern=Another+some&term=1&glob=subdir/*", nil))
+	require.Equal(ss.T(), http.StatusOK, term.Code)
+	require.Equal(ss.T(), rec.Body.String(), term.Body.String(), "term query")
+
+	// stream is not cut by server write timeout
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(wtc.wtServer.ServeSearch))
+	srv.Config.WriteTimeout = time.Nanosecond
+	srv.Start()
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/search?pattern=another&glob=subdir/*")
+	require.NoError(ss.T(), err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(ss.T(), err)
+	require.Equal(ss.T(), rec.Body.String(), string(body))
+}
+
 func (ss *ServerSuite) TODOTestTail() {
 	wtc, err := NewWebTailClient(ss.T(), &ss.cfg)
 	require.NoError(ss.T(), err)
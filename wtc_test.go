@@ -127,9 +127,13 @@ func (wtc *WebTailClient) Listener(limit int) {
 		for i := range result {
 			val, err := djson.DecodeObject(result[i])
 			require.Nil(t, err)
-			if val["type"] == "index" {
+			if val["type"] == "index_list" {
+				for _, item := range val["data"].([]interface{}) {
+					skipVolatile(item.(map[string]interface{}))
+				}
+			} else if val["type"] == "index" {
 				d := val["data"].(map[string]interface{})
-				delete(d, "mtime")
+				skipVolatile(d)
 				val["data"] = d
 				if d["name"] == RootFile {
 					wtc.t.Log("SyncIndex sent")
@@ -174,3 +178,10 @@ func wsclose(c *websocket.Conn, done chan struct{}) {
 	}
 	c.Close()
 }
+
+// skipVolatile removes index item attrs which depend on environment
+func skipVolatile(item map[string]interface{}) {
+	for _, k := range []string{"mtime", "owner", "mode", "rate"} {
+		delete(item, k)
+	}
+}